@@ -0,0 +1,326 @@
+package storaged
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/user"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const approvalStateFile = "approvals.json"
+
+// ApprovalPolicy requires large folders to be approved before they are created or grown.
+type ApprovalPolicy struct {
+	// AboveBytes is the folder quota above which creating or growing a folder needs approval.
+	AboveBytes int `toml:"above_bytes"`
+	// Approvers are the groups whose members may approve or deny requests.
+	Approvers []string `toml:"approvers"`
+}
+
+// Validate returns an error if the policy would not require approval from anyone.
+func (p ApprovalPolicy) Validate() error {
+	if p.AboveBytes < 0 {
+		return errors.New("approval threshold cannot be negative")
+	}
+	if p.AboveBytes > 0 && len(p.Approvers) == 0 {
+		return errors.New("approval threshold is set without any approvers")
+	}
+	return nil
+}
+
+// PendingRequest is a folder update waiting to be approved.
+type PendingRequest struct {
+	ID      string        `json:"id"`
+	User    string        `json:"user"`
+	Request UpdateRequest `json:"request"`
+	// Approvers are the groups whose members may decide on the request.
+	Approvers []string  `json:"approvers"`
+	Created   time.Time `json:"created"`
+}
+
+// Describe returns a human-readable summary of the request.
+func (p PendingRequest) Describe() string {
+	desc := fmt.Sprintf(
+		"%s requested %s for %s in %s",
+		p.User, FormatByteSize(p.Request.SizeInGB*1000*1000*1000), p.Request.Name, p.Request.Tier,
+	)
+	if p.Request.Pool != "" {
+		desc += " charged to pool " + p.Request.Pool
+	}
+	return desc + " on " + p.Created.Format(time.DateTime)
+}
+
+// approvalStore is the persistent list of requests waiting to be approved.
+type approvalStore struct {
+	mutex   sync.Mutex
+	path    string
+	pending []PendingRequest
+}
+
+func loadApprovalStore(statePath string) (*approvalStore, error) {
+	store := &approvalStore{path: statePath}
+	err := loadState(statePath, &store.pending)
+	if err != nil {
+		return nil, fmt.Errorf("error loading pending requests: %w", err)
+	}
+	return store, nil
+}
+
+// List returns all pending requests.
+func (a *approvalStore) List() []PendingRequest {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return slices.Clone(a.pending)
+}
+
+// Requested returns the pending requests made by the user.
+func (a *approvalStore) Requested(userName string) []PendingRequest {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	var requested []PendingRequest
+	for _, pending := range a.pending {
+		if pending.User == userName {
+			requested = append(requested, pending)
+		}
+	}
+	return requested
+}
+
+// Get returns the pending request with the specified ID.
+func (a *approvalStore) Get(id string) (PendingRequest, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	idx := slices.IndexFunc(a.pending, func(p PendingRequest) bool { return p.ID == id })
+	if idx == -1 {
+		return PendingRequest{}, false
+	}
+	return a.pending[idx], true
+}
+
+// Add stores the request, assigning it a new ID. An earlier request by the same user for the same
+// folder is replaced.
+func (a *approvalStore) Add(pending PendingRequest) (PendingRequest, error) {
+	var err error
+	pending.ID, err = randomID()
+	if err != nil {
+		return PendingRequest{}, err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	remaining := slices.DeleteFunc(slices.Clone(a.pending), func(p PendingRequest) bool {
+		return p.User == pending.User && p.Request.Tier == pending.Request.Tier && p.Request.Name == pending.Request.Name
+	})
+	remaining = append(remaining, pending)
+	err = saveState(a.path, remaining)
+	if err != nil {
+		return PendingRequest{}, fmt.Errorf("error saving pending requests: %w", err)
+	}
+	a.pending = remaining
+	return pending, nil
+}
+
+// Remove deletes the pending request with the specified ID.
+func (a *approvalStore) Remove(id string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	remaining := slices.DeleteFunc(slices.Clone(a.pending), func(p PendingRequest) bool { return p.ID == id })
+	if len(remaining) == len(a.pending) {
+		return fmt.Errorf("request %q does not exist", id)
+	}
+	err := saveState(a.path, remaining)
+	if err != nil {
+		return fmt.Errorf("error saving pending requests: %w", err)
+	}
+	a.pending = remaining
+	return nil
+}
+
+// requiredApprovers returns the groups that may approve a folder in the tier growing to
+// quotaRequested, or nil if no approval is needed. Both the policy of the tier and those of the
+// allocations the folder is charged to apply.
+func (s *Server) requiredApprovers(groups []string, tierName, pool string, quotaRequested int) []string {
+	policies := []ApprovalPolicy{s.ApprovalPolicies[tierName]}
+	chargedGroups := groups
+	allocations := s.Allocations
+	if pool != "" {
+		chargedGroups = []string{pool}
+		allocations = s.Pools
+	}
+	for _, group := range chargedGroups {
+		for _, allocation := range allocations[group] {
+			if allocation.Tier == tierName && allocation.Approval != nil {
+				policies = append(policies, *allocation.Approval)
+			}
+		}
+	}
+	var approvers []string
+	for _, policy := range policies {
+		if policy.AboveBytes > 0 && quotaRequested > policy.AboveBytes {
+			approvers = append(approvers, policy.Approvers...)
+		}
+	}
+	slices.Sort(approvers)
+	return slices.Compact(approvers)
+}
+
+// requestApproval stores the update as pending approval.
+func (s *Server) requestApproval(
+	submitter *user.User, updateReq UpdateRequest, approvers []string,
+) (statusCode int, output string) {
+	pending, err := s.approvals.Add(PendingRequest{
+		User:      submitter.Username,
+		Request:   updateReq,
+		Approvers: approvers,
+		Created:   time.Now(),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to submit request for approval: " + err.Error()
+	}
+	return http.StatusAccepted, fmt.Sprintf(
+		"Folders of this size need to be approved by a member of %s.\n"+
+			"Your request %s has been submitted and will be applied once it is approved.",
+		strings.Join(approvers, ", "), pending.ID,
+	)
+}
+
+// canDecide returns whether the user may approve or deny the pending request.
+func (s *Server) canDecide(u *user.User, pending PendingRequest) (bool, error) {
+	groups, err := groupNames(u)
+	if err != nil {
+		return false, err
+	}
+	for _, group := range groups {
+		if slices.Contains(pending.Approvers, group) {
+			return true, nil
+		}
+	}
+	return s.isAdmin(u)
+}
+
+func (s *Server) handleApprovals(writer http.ResponseWriter, req *http.Request) {
+	var approvalReq ApprovalRequest
+	submitter, ok := s.readRequest(writer, req, &approvalReq)
+	if !ok {
+		return
+	}
+	status, output := s.attemptApproval(req.Context(), submitter, approvalReq)
+	if status == http.StatusInternalServerError {
+		output += "\n\nTry again later and contact administrators if the folder is in an unexpected state."
+	}
+	writer.WriteHeader(status)
+	_, _ = fmt.Fprintln(writer, output)
+}
+
+func (s *Server) attemptApproval(
+	ctx context.Context, submitter *user.User, approvalReq ApprovalRequest,
+) (statusCode int, output string) {
+	if approvalReq.Action == "list" {
+		var sb strings.Builder
+		for _, pending := range s.approvals.List() {
+			canDecide, err := s.canDecide(submitter, pending)
+			if err != nil {
+				return http.StatusInternalServerError, "Failed to check approver status: " + err.Error()
+			}
+			if canDecide || pending.User == submitter.Username {
+				_, _ = fmt.Fprintf(&sb, "%s: %s\n", pending.ID, pending.Describe())
+			}
+		}
+		if sb.Len() == 0 {
+			return http.StatusOK, "There are no requests waiting for approval."
+		}
+		return http.StatusOK, strings.TrimSuffix(sb.String(), "\n")
+	}
+	if approvalReq.Action != "approve" && approvalReq.Action != "deny" {
+		return http.StatusBadRequest, fmt.Sprintf("Invalid action requested: %q", approvalReq.Action)
+	}
+	pending, ok := s.approvals.Get(approvalReq.ID)
+	if !ok {
+		return http.StatusNotFound, "Request " + approvalReq.ID + " does not exist."
+	}
+	canDecide, err := s.canDecide(submitter, pending)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check approver status: " + err.Error()
+	}
+	withdrawing := approvalReq.Action == "deny" && pending.User == submitter.Username
+	if !canDecide && !withdrawing {
+		return http.StatusNotFound, "Request " + approvalReq.ID + " does not exist."
+	}
+
+	if approvalReq.Action == "deny" {
+		err := s.approvals.Remove(pending.ID)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to deny request: " + err.Error()
+		}
+		if withdrawing {
+			return http.StatusOK, "Your request " + pending.ID + " has been withdrawn."
+		}
+		message := fmt.Sprintf(
+			"Your request for %s for %s in %s has been denied by %s.",
+			FormatByteSize(pending.Request.SizeInGB*1000*1000*1000), pending.Request.Name,
+			pending.Request.Tier, submitter.Username,
+		)
+		if approvalReq.Reason != "" {
+			message += " Reason: " + approvalReq.Reason
+		}
+		s.notify(Event{
+			Kind:    EventRequestDenied,
+			User:    pending.User,
+			Tier:    pending.Request.Tier,
+			Folder:  pending.Request.Name,
+			Message: message,
+		})
+		return http.StatusOK, "Request " + pending.ID + " has been denied."
+	}
+
+	if pending.User == submitter.Username {
+		return http.StatusBadRequest, "You cannot approve your own request."
+	}
+	requester, err := user.Lookup(pending.User)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to look up requester: " + err.Error()
+	}
+	status, output := s.attemptAssign(ctx, requester, pending.Request, true)
+	if status == http.StatusInternalServerError {
+		// Keep the request so that it can be approved again once the problem is fixed.
+		return status, "Failed to apply the approved request:\n" + output
+	}
+	err = s.approvals.Remove(pending.ID)
+	if err != nil {
+		log.Printf("failed to remove decided request %s: %v", pending.ID, err)
+	}
+	if status != http.StatusOK {
+		// The request no longer fits, e.g. because the allocation has been used up since, so it
+		// cannot be approved as it is.
+		s.notify(Event{
+			Kind:   EventRequestDenied,
+			User:   pending.User,
+			Tier:   pending.Request.Tier,
+			Folder: pending.Request.Name,
+			Message: fmt.Sprintf(
+				"Your request for %s for %s in %s could no longer be applied and nothing has been changed.\n%s",
+				FormatByteSize(pending.Request.SizeInGB*1000*1000*1000), pending.Request.Name,
+				pending.Request.Tier, output,
+			),
+		})
+		return status, "The request could no longer be applied and has been removed:\n" + output
+	}
+	s.notify(Event{
+		Kind:   EventRequestApproved,
+		User:   pending.User,
+		Tier:   pending.Request.Tier,
+		Folder: pending.Request.Name,
+		Bytes:  pending.Request.SizeInGB * 1000 * 1000 * 1000,
+		Message: fmt.Sprintf(
+			"Your request for %s for %s in %s has been approved by %s.\n%s",
+			FormatByteSize(pending.Request.SizeInGB*1000*1000*1000), pending.Request.Name,
+			pending.Request.Tier, submitter.Username, output,
+		),
+	})
+	return http.StatusOK, "Request " + pending.ID + " has been approved and applied."
+}
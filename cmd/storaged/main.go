@@ -1,11 +1,13 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/NTUEEECluster/storaged"
@@ -27,11 +29,41 @@ func main() {
 }
 
 type Config struct {
-	ListenAddr        string                           `toml:"listen_addr"`
-	AllowedEncodeHost string                           `toml:"allowed_encode_host"`
-	ProjectDir        string                           `toml:"project_dir"`
-	TierDir           map[string]string                `toml:"tier_dir"`
-	Allocations       map[string][]storaged.Allocation `toml:"allocations"`
+	ListenAddr          string                             `toml:"listen_addr"`
+	AllowedEncodeHost   string                             `toml:"allowed_encode_host"`
+	MetricsAllowedHost  string                             `toml:"metrics_allowed_host"`
+	MetricsUserUsage    bool                               `toml:"metrics_user_usage"`
+	ProjectDir          string                             `toml:"project_dir"`
+	TierDir             map[string]string                  `toml:"tier_dir"`
+	Allocations         map[string][]storaged.Allocation   `toml:"allocations"`
+	Pools               map[string][]storaged.Allocation   `toml:"pools"`
+	AdminGroups         []string                           `toml:"admin_groups"`
+	StateDir            string                             `toml:"state_dir"`
+	GrantExpiryWarning  time.Duration                      `toml:"grant_expiry_warning"`
+	GracePeriod         time.Duration                      `toml:"grace_period"`
+	GracePolicy         storaged.GracePolicy               `toml:"grace_policy"`
+	SoftThresholds      []int                              `toml:"soft_thresholds"`
+	ScanInterval        time.Duration                      `toml:"scan_interval"`
+	ScanWorkers         int                                `toml:"scan_workers"`
+	MaxBreakdownDepth   int                                `toml:"max_breakdown_depth"`
+	HistoryInterval     time.Duration                      `toml:"history_interval"`
+	HistoryRawRetention time.Duration                      `toml:"history_raw_retention"`
+	HistoryRetention    time.Duration                      `toml:"history_retention"`
+	ForecastWindow      time.Duration                      `toml:"forecast_window"`
+	ForecastHorizon     time.Duration                      `toml:"forecast_horizon"`
+	ForecastModel       storaged.ForecastModel             `toml:"forecast_model"`
+	TrashRetention      time.Duration                      `toml:"trash_retention"`
+	FreezeTrash         bool                               `toml:"freeze_trash"`
+	MaxJobsPerUser      int                                `toml:"max_jobs_per_user"`
+	Snapshots           map[string]storaged.SnapshotPolicy `toml:"snapshots"`
+	Expiry              map[string]storaged.ExpiryPolicy   `toml:"expiry"`
+	Approvals           map[string]storaged.ApprovalPolicy `toml:"approvals"`
+	IdempotencyTTL      time.Duration                      `toml:"idempotency_ttl"`
+	IPA                 *storaged.IPAClientConfig          `toml:"ipa"`
+	SMTP                *storaged.SMTPConfig               `toml:"smtp"`
+	EmailDomain         string                             `toml:"email_domain"`
+	Webhooks            []storaged.WebhookConfig           `toml:"webhooks"`
+	WebhookDeadLetter   string                             `toml:"webhook_dead_letter"`
 }
 
 func run(cfg Config) error {
@@ -53,16 +85,100 @@ func run(cfg Config) error {
 		}
 		tiers[tierName] = tierFS
 	}
+	for _, allocations := range []map[string][]storaged.Allocation{cfg.Allocations, cfg.Pools} {
+		for group, groupAllocations := range allocations {
+			for _, allocation := range groupAllocations {
+				err := allocation.Policy.Validate()
+				if err != nil {
+					return fmt.Errorf("error validating allocation of %q: %w", group, err)
+				}
+			}
+		}
+	}
+	err = cfg.GracePolicy.Validate()
+	if err != nil {
+		return fmt.Errorf("error validating grace policy: %w", err)
+	}
+	err = cfg.ForecastModel.Validate()
+	if err != nil {
+		return fmt.Errorf("error validating forecast model: %w", err)
+	}
 	_, allowedEncodeHost, err := net.ParseCIDR(cfg.AllowedEncodeHost)
 	if err != nil {
 		return fmt.Errorf("error parsing allowed encoding host: %w", err)
 	}
-	srv := storaged.NewServer(storaged.ServerConfig{
-		AllowedEncodeHost: allowedEncodeHost,
-		ProjectFS:         projectDir,
-		Tiers:             tiers,
-		Allocations:       cfg.Allocations,
+	var metricsAllowedHost *net.IPNet
+	if cfg.MetricsAllowedHost != "" {
+		_, metricsAllowedHost, err = net.ParseCIDR(cfg.MetricsAllowedHost)
+		if err != nil {
+			return fmt.Errorf("error parsing allowed metrics host: %w", err)
+		}
+	}
+	var ipaClient *storaged.IPAClient
+	if cfg.IPA != nil {
+		ipaClient, err = storaged.NewIPAClient(*cfg.IPA)
+		if err != nil {
+			return fmt.Errorf("error connecting to FreeIPA: %w", err)
+		}
+	}
+	var notifiers storaged.MultiNotifier
+	if cfg.SMTP != nil {
+		var resolver storaged.EmailResolver = storaged.EmailDomain(cfg.EmailDomain)
+		if cfg.EmailDomain == "" {
+			if ipaClient == nil {
+				return errors.New("either email_domain or ipa must be configured to send emails")
+			}
+			resolver = ipaClient
+		}
+		smtpNotifier, err := storaged.NewSMTPNotifier(*cfg.SMTP, resolver)
+		if err != nil {
+			return fmt.Errorf("error initializing SMTP notifier: %w", err)
+		}
+		notifiers = append(notifiers, smtpNotifier)
+	}
+	if len(cfg.Webhooks) > 0 {
+		notifiers = append(notifiers, storaged.NewWebhookNotifier(cfg.Webhooks, cfg.WebhookDeadLetter))
+	}
+	var notifier storaged.Notifier = storaged.LogNotifier{}
+	if len(notifiers) > 0 {
+		notifier = notifiers
+	}
+	srv, err := storaged.NewServer(storaged.ServerConfig{
+		AllowedEncodeHost:   allowedEncodeHost,
+		MetricsAllowedHost:  metricsAllowedHost,
+		MetricsUserUsage:    cfg.MetricsUserUsage,
+		ProjectFS:           projectDir,
+		Tiers:               tiers,
+		Allocations:         cfg.Allocations,
+		Pools:               cfg.Pools,
+		AdminGroups:         cfg.AdminGroups,
+		StateDir:            cfg.StateDir,
+		GrantExpiryWarning:  cfg.GrantExpiryWarning,
+		GracePeriod:         cfg.GracePeriod,
+		GracePolicy:         cfg.GracePolicy,
+		SoftThresholds:      cfg.SoftThresholds,
+		ScanInterval:        cfg.ScanInterval,
+		ScanWorkers:         cfg.ScanWorkers,
+		MaxBreakdownDepth:   cfg.MaxBreakdownDepth,
+		HistoryInterval:     cfg.HistoryInterval,
+		HistoryRawRetention: cfg.HistoryRawRetention,
+		HistoryRetention:    cfg.HistoryRetention,
+		ForecastWindow:      cfg.ForecastWindow,
+		ForecastHorizon:     cfg.ForecastHorizon,
+		ForecastModel:       cfg.ForecastModel,
+		TrashRetention:      cfg.TrashRetention,
+		FreezeTrash:         cfg.FreezeTrash,
+		MaxJobsPerUser:      cfg.MaxJobsPerUser,
+		SnapshotPolicies:    cfg.Snapshots,
+		ExpiryPolicies:      cfg.Expiry,
+		ApprovalPolicies:    cfg.Approvals,
+		IdempotencyTTL:      cfg.IdempotencyTTL,
+		Notifier:            notifier,
+		IPA:                 ipaClient,
 	})
+	if err != nil {
+		return fmt.Errorf("error initializing server: %w", err)
+	}
 	err = srv.Listen(cfg.ListenAddr)
 	if err != nil {
 		return fmt.Errorf("error listening: %w", err)
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+)
+
+// NewApprovalsModel lists the requests waiting for approval that the user may decide on or made.
+func NewApprovalsModel(hostURL string) jobsModel {
+	return jobsModel{
+		webModel:  newApprovalsRequest(hostURL),
+		helpModel: help.New(),
+	}
+}
+
+func NewDecideModel(hostURL string) formModel {
+	return newFormModel(
+		[]formField{
+			{Header: "Request ID", Input: newInput(10, 8, "1a2b3c4d", validateRequestID)},
+			{Header: "Decision (approve or deny)", Input: newInput(10, 7, "approve", validateDecision)},
+			{
+				Header: "Reason",
+				Input: newInput(
+					50, 200, "Optional, sent to the requester when denying", func(string) error { return nil },
+				),
+			},
+		},
+		func(values []string) webRequestModel {
+			return newDecideRequest(hostURL, values[0], values[1], values[2])
+		},
+	)
+}
+
+func validateRequestID(id string) error {
+	if strings.TrimSpace(id) == "" {
+		return errors.New("request ID is required")
+	}
+	return validateDeletedID(id)
+}
+
+func validateDecision(decision string) error {
+	switch strings.TrimSpace(decision) {
+	case "approve", "deny":
+		return nil
+	default:
+		return errors.New("decision must be approve or deny")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/NTUEEECluster/storaged"
+)
+
+func NewBreakdownModel(hostURL string) formModel {
+	return newFormModel(
+		[]formField{
+			{Header: "Folder Name to Break Down", Input: newInput(22, 20, "ExampleProj1", storaged.ValidateProjectName)},
+			{Header: "Storage Tier", Input: newInput(20, 15, "hdd", validateTierName)},
+			{Header: "Depth", Input: newInput(5, 1, "1", validateDepth), Inline: true},
+		},
+		func(values []string) webRequestModel {
+			depth := 0
+			if values[2] != "" {
+				var err error
+				depth, err = strconv.Atoi(values[2])
+				if err != nil {
+					panic("unexpected error in depth when validated: " + err.Error())
+				}
+			}
+			return newBreakdownRequest(hostURL, values[0], values[1], depth)
+		},
+	)
+}
+
+func validateDepth(depth string) error {
+	if strings.TrimSpace(depth) == "" {
+		return nil
+	}
+	v, err := strconv.Atoi(depth)
+	if err != nil || v < 1 {
+		return errors.New("depth must be a positive number")
+	}
+	return nil
+}
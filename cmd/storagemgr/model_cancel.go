@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+func NewCancelJobModel(hostURL string) formModel {
+	return newFormModel(
+		[]formField{
+			{Header: "Operation ID to Cancel", Input: newInput(10, 8, "1a2b3c4d", validateJobID)},
+		},
+		func(values []string) webRequestModel {
+			return newCancelJobRequest(hostURL, values[0])
+		},
+	)
+}
+
+func validateJobID(id string) error {
+	if strings.TrimSpace(id) == "" {
+		return errors.New("operation ID is required")
+	}
+	return validateDeletedID(id)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// formField is an input of a form along with the header shown above it.
+type formField struct {
+	Header string
+	Input  textinput.Model
+	// Inline places the field to the right of the previous field instead of below it.
+	Inline bool
+}
+
+// formModel asks for its fields and sends the request built from them once submitted.
+type formModel struct {
+	Fields []formField
+	// Check validates the fields against each other once each of them is valid on its own. It
+	// returns the index of the field to fix along with the problem, or a nil error if the form can
+	// be submitted. It may be nil.
+	Check func(values []string) (int, error)
+	// Warning is shown instead of the usual status once the form can be submitted.
+	Warning string
+	// Request builds the request to send from the values of the fields, with spaces trimmed.
+	Request func(values []string) webRequestModel
+
+	focus       int
+	madeRequest bool
+	webModel    webRequestModel
+	helpModel   help.Model
+}
+
+func newFormModel(fields []formField, request func(values []string) webRequestModel) formModel {
+	fields[0].Input.Focus()
+	return formModel{
+		Fields:    fields,
+		Request:   request,
+		helpModel: help.New(),
+	}
+}
+
+// newInput returns a text input for a form.
+func newInput(width, charLimit int, placeholder string, validate textinput.ValidateFunc) textinput.Model {
+	input := textinput.New()
+	input.Width = width
+	input.CharLimit = charLimit
+	input.Placeholder = placeholder
+	input.Validate = validate
+	return input
+}
+
+func (formModel) Init() tea.Cmd { return nil }
+
+func (m formModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if m.madeRequest {
+		return m.updateResult(msg)
+	}
+	return m.updateForm(msg)
+}
+
+func (m formModel) View() string {
+	if m.madeRequest {
+		return fmt.Sprintf(
+			"%s\n\n%s\n",
+			m.webModel.View(),
+			m.helpModel.ShortHelpView([]key.Binding{keybindContinue}),
+		)
+	}
+
+	statusDisplay := OKStyle.Render("Ready for submission.")
+	if m.Warning != "" {
+		statusDisplay = WarningStyle.Render(m.Warning)
+	}
+	_, err := m.validate()
+	if err != nil {
+		errMsg := err.Error()
+		if len(errMsg) > 0 {
+			errMsg = strings.ToUpper(errMsg[:1]) + errMsg[1:]
+		}
+		statusDisplay = ErrorStyle.Render(errMsg)
+	}
+
+	var keybinds []key.Binding
+	if len(m.Fields) > 1 {
+		keybinds = append(keybinds, keybindPrev, keybindNext)
+	}
+	if err == nil {
+		keybinds = append(keybinds, keybindSubmit)
+	}
+	keybinds = append(keybinds, keybindCancel)
+
+	var sb strings.Builder
+	for i := 0; i < len(m.Fields); {
+		row := []formField{m.Fields[i]}
+		for i++; i < len(m.Fields) && m.Fields[i].Inline; i++ {
+			row = append(row, m.Fields[i])
+		}
+		headers := make([]string, len(row))
+		inputs := make([]string, len(row))
+		for j, field := range row {
+			inputs[j] = field.Input.View()
+			headers[j] = InputHeaderStyle.Render(field.Header)
+			if j < len(row)-1 {
+				// Line the headers up with the inputs below them.
+				headers[j] = InputHeaderStyle.Width(lipgloss.Width(inputs[j])).Render(field.Header)
+			}
+		}
+		_, _ = fmt.Fprintf(&sb, "%s\n%s\n\n", strings.Join(headers, "  "), strings.Join(inputs, "  "))
+	}
+	_, _ = fmt.Fprintf(&sb, "%s\n%s\n", statusDisplay, m.helpModel.ShortHelpView(keybinds))
+	return sb.String()
+}
+
+// validate returns the index of the first field that needs to be fixed and why, or a nil error if
+// the form can be submitted.
+func (m formModel) validate() (int, error) {
+	for i, field := range m.Fields {
+		err := field.Input.Validate(field.Input.Value())
+		if err != nil {
+			return i, err
+		}
+	}
+	if m.Check == nil {
+		return 0, nil
+	}
+	return m.Check(m.values())
+}
+
+// values returns the values of the fields with spaces trimmed.
+func (m formModel) values() []string {
+	values := make([]string, len(m.Fields))
+	for i, field := range m.Fields {
+		values[i] = strings.TrimSpace(field.Input.Value())
+	}
+	return values
+}
+
+func (m formModel) updateForm(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		for i := range m.Fields {
+			m.Fields[i].Input.Blur()
+		}
+		switch {
+		case key.Matches(msg, keybindCancel):
+			return m, ReturnToList
+		case key.Matches(msg, keybindPrev):
+			m.focus += len(m.Fields) - 1 // Equivalent to -1.
+			m.focus %= len(m.Fields)
+		case key.Matches(msg, keybindNext):
+			m.focus++
+			m.focus %= len(m.Fields)
+		case key.Matches(msg, keybindSubmit):
+			invalid, err := m.validate()
+			if err != nil {
+				m.focus = invalid
+				break
+			}
+			m.webModel = m.Request(m.values())
+			m.madeRequest = true
+			return m, m.webModel.Init()
+		}
+		m.Fields[m.focus].Input.Focus()
+	}
+	cmds := make([]tea.Cmd, len(m.Fields))
+	for i := range m.Fields {
+		m.Fields[i].Input, cmds[i] = m.Fields[i].Input.Update(msg)
+	}
+	return m, tea.Batch(cmds...)
+}
+
+func (m formModel) updateResult(msg tea.Msg) (tea.Model, tea.Cmd) {
+	webModel, cmd := m.webModel.Update(msg)
+	m.webModel = webModel.(webRequestModel)
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if key.Matches(msg, keybindContinue) {
+			return m, tea.Batch(cmd, ReturnToList)
+		}
+	}
+	return m, cmd
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+var keybindRefresh = key.NewBinding(
+	key.WithKeys("r"),
+	key.WithHelp("r", "refresh"),
+)
+
+type jobsModel struct {
+	webModel  webRequestModel
+	helpModel help.Model
+}
+
+func NewJobsModel(hostURL string) jobsModel {
+	return jobsModel{
+		webModel:  newJobsRequest(hostURL),
+		helpModel: help.New(),
+	}
+}
+
+func (m jobsModel) Init() tea.Cmd {
+	return m.webModel.Init()
+}
+
+func (m jobsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	webModel, cmd := m.webModel.Update(msg)
+	m.webModel = webModel.(webRequestModel)
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, keybindContinue):
+			return m, tea.Batch(cmd, ReturnToList)
+		case key.Matches(msg, keybindRefresh) && m.webModel.Response != nil:
+			var redoCmd tea.Cmd
+			m.webModel, redoCmd = m.webModel.RedoRequest()
+			return m, tea.Batch(cmd, redoCmd)
+		}
+	}
+	return m, cmd
+}
+
+func (m jobsModel) View() string {
+	return fmt.Sprintf(
+		"%s\n\n%s\n",
+		m.webModel.View(),
+		m.helpModel.ShortHelpView([]key.Binding{keybindRefresh, keybindContinue}),
+	)
+}
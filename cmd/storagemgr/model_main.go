@@ -13,6 +13,7 @@ var (
 	ErrorStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF7777"))
 	LoadingStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00"))
 	OKStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("#00FF00"))
+	WarningStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFAA00"))
 
 	ItemStyle         = lipgloss.NewStyle().PaddingLeft(4)
 	SelectedItemStyle = lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("170"))
@@ -54,6 +55,16 @@ func newStorageModel(hostURL string, username string) storageModel {
 			{"Create New Folder", func() tea.Model { return NewQuotaModel(hostURL, "Create", false) }},
 			{"Update Quota for Folder", func() tea.Model { return NewQuotaModel(hostURL, "Update", false) }},
 			{"Delete Folder", func() tea.Model { return NewQuotaModel(hostURL, "Delete", true) }},
+			{"Permanently Delete Folder", func() tea.Model { return NewPurgeModel(hostURL) }},
+			{"Restore Deleted Folder", func() tea.Model { return NewRestoreModel(hostURL) }},
+			{"Rename Folder", func() tea.Model { return NewRenameModel(hostURL) }},
+			{"Migrate Folder to Another Tier", func() tea.Model { return NewMigrateModel(hostURL) }},
+			{"List Folder Snapshots", func() tea.Model { return NewSnapshotsModel(hostURL) }},
+			{"Show Folder Usage Breakdown", func() tea.Model { return NewBreakdownModel(hostURL) }},
+			{"Show Operation Progress", func() tea.Model { return NewJobsModel(hostURL) }},
+			{"Cancel Operation", func() tea.Model { return NewCancelJobModel(hostURL) }},
+			{"Review Pending Requests", func() tea.Model { return NewApprovalsModel(hostURL) }},
+			{"Approve or Deny Request", func() tea.Model { return NewDecideModel(hostURL) }},
 			{"Quit", func() tea.Model { return quitModel{} }},
 		}),
 	}
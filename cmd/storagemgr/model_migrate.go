@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/NTUEEECluster/storaged"
+)
+
+func NewMigrateModel(hostURL string) formModel {
+	m := newFormModel(
+		[]formField{
+			{Header: "Folder Name to Migrate", Input: newInput(22, 20, "ExampleProj1", storaged.ValidateProjectName)},
+			{Header: "Current Tier", Input: newInput(20, 15, "hdd", validateTierName)},
+			{Header: "Destination Tier", Input: newInput(20, 15, "ssd", validateTierName), Inline: true},
+		},
+		func(values []string) webRequestModel {
+			return newMigrateRequest(hostURL, values[0], values[1], values[2])
+		},
+	)
+	m.Check = func(values []string) (int, error) {
+		if values[1] == values[2] {
+			return 2, errors.New("destination tier must be different from the current tier")
+		}
+		return 0, nil
+	}
+	return m
+}
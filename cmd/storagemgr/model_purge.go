@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/NTUEEECluster/storaged"
+)
+
+func NewPurgeModel(hostURL string) formModel {
+	m := newFormModel(
+		[]formField{
+			{
+				Header: "Folder Name to Delete Permanently",
+				Input:  newInput(22, 20, "ExampleProj1", storaged.ValidateProjectName),
+			},
+			{Header: "Storage Tier", Input: newInput(20, 15, "hdd", validateTierName)},
+			{
+				Header: "Repeat Folder Name to Confirm",
+				Input:  newInput(22, 20, "ExampleProj1", storaged.ValidateProjectName),
+			},
+		},
+		func(values []string) webRequestModel {
+			return newPurgeRequest(hostURL, values[0], values[1], values[2])
+		},
+	)
+	m.Check = func(values []string) (int, error) {
+		if values[0] != values[2] {
+			return 2, errors.New("repeat the folder name to confirm")
+		}
+		return 0, nil
+	}
+	m.Warning = "⚠ The folder and everything in it will be deleted. This cannot be undone."
+	return m
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/NTUEEECluster/storaged"
 	"github.com/charmbracelet/bubbles/help"
@@ -13,10 +15,16 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 )
 
+var keybindPreview = key.NewBinding(
+	key.WithKeys("ctrl+r"),
+	key.WithHelp("ctrl+r", "preview"),
+)
+
 type quotaModel struct {
 	Inputs      []textinput.Model
 	focus       int
 	madeRequest bool
+	previewing  bool
 	webModel    webRequestModel
 	helpModel   help.Model
 
@@ -45,8 +53,18 @@ func NewQuotaModel(hostURL string, actionDesc string, isDelete bool) quotaModel
 	if isDelete {
 		size.SetValue("0")
 	}
+	pool := textinput.New()
+	pool.Width = 20
+	pool.CharLimit = 32
+	pool.Placeholder = "(none)"
+	pool.Validate = validatePoolName
+	expiresOn := textinput.New()
+	expiresOn.Width = 12
+	expiresOn.CharLimit = 10
+	expiresOn.Placeholder = "(unchanged)"
+	expiresOn.Validate = validateExpiresOn
 	return quotaModel{
-		Inputs:      []textinput.Model{projectName, tier, size},
+		Inputs:      []textinput.Model{projectName, tier, size, pool, expiresOn},
 		focus:       0,
 		madeRequest: false,
 		helpModel:   help.New(),
@@ -90,11 +108,22 @@ func (m quotaModel) View() string {
 		}
 	}
 
-	keybinds := []key.Binding{keybindPrev, keybindNext, keybindSubmit, keybindCancel}
+	keybinds := []key.Binding{keybindPrev, keybindNext, keybindSubmit, keybindPreview, keybindCancel}
 	if hasError {
 		keybinds = []key.Binding{keybindPrev, keybindNext, keybindCancel}
 	}
 
+	poolInput := ""
+	if !m.IsDelete {
+		poolInput = fmt.Sprintf(
+			"%s\n%s\n\n%s\n%s\n\n",
+			InputHeaderStyle.Render("Charge to Pool (optional)"),
+			m.Inputs[3].View(),
+			InputHeaderStyle.Render("Keep Until (YYYY-MM-DD, optional)"),
+			m.Inputs[4].View(),
+		)
+	}
+
 	return fmt.Sprintf(
 		"%s\n"+
 			"%s\n"+
@@ -102,12 +131,14 @@ func (m quotaModel) View() string {
 			"%s  %s\n"+
 			"%s  %s\n"+
 			"\n"+
+			"%s"+
 			"%s\n"+
 			"%s\n",
 		InputHeaderStyle.Render("Folder Name to "+m.ActionDesc),
 		m.Inputs[0].View(),
 		InputHeaderStyle.Width(20).Render("Storage Tier"), InputHeaderStyle.Render("New Folder Size (GB)"),
 		m.Inputs[1].View(), m.Inputs[2].View(),
+		poolInput,
 		statusDisplay,
 		m.helpModel.ShortHelpView(keybinds),
 	)
@@ -116,7 +147,7 @@ func (m quotaModel) View() string {
 func (m quotaModel) updateForm(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		validInputCount := 3
+		validInputCount := 5
 		if m.IsDelete {
 			validInputCount = 2
 		}
@@ -132,7 +163,7 @@ func (m quotaModel) updateForm(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, keybindNext):
 			m.focus++
 			m.focus %= validInputCount
-		case key.Matches(msg, keybindSubmit):
+		case key.Matches(msg, keybindSubmit), key.Matches(msg, keybindPreview):
 			hasErr := false
 			for i, input := range m.Inputs {
 				if input.Validate(input.Value()) != nil {
@@ -150,7 +181,10 @@ func (m quotaModel) updateForm(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if err != nil {
 				panic("unexpected error in size when validated: " + err.Error())
 			}
-			m.webModel = newUpdateRequest(m.HostURL, folderName, tierName, sizeInGB)
+			poolName := strings.TrimSpace(m.Inputs[3].Value())
+			expiresOn := strings.TrimSpace(m.Inputs[4].Value())
+			m.previewing = key.Matches(msg, keybindPreview)
+			m.webModel = newUpdateRequest(m.HostURL, folderName, tierName, sizeInGB, poolName, expiresOn, m.previewing)
 			m.madeRequest = true
 			return m, m.webModel.Init()
 		}
@@ -168,7 +202,13 @@ func (m quotaModel) updateResult(msg tea.Msg) (tea.Model, tea.Cmd) {
 	m.webModel = webModel.(webRequestModel)
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		if key.Matches(msg, keybindContinue) {
+		switch {
+		case key.Matches(msg, keybindContinue) && m.previewing && m.webModel.Response != nil:
+			// Go back to the form so that the update can be adjusted or submitted.
+			m.madeRequest = false
+			m.Inputs[m.focus].Focus()
+			return m, cmd
+		case key.Matches(msg, keybindContinue):
 			return m, tea.Batch(cmd, ReturnToList)
 		}
 	}
@@ -182,6 +222,13 @@ func validateTierName(tier string) error {
 	return nil
 }
 
+func validatePoolName(pool string) error {
+	if strings.ContainsFunc(strings.TrimSpace(pool), unicode.IsSpace) {
+		return errors.New("pool name must not contain spaces")
+	}
+	return nil
+}
+
 func validateSize(size string) error {
 	if strings.TrimSpace(size) == "" {
 		return errors.New("size is required")
@@ -195,3 +242,15 @@ func validateSize(size string) error {
 	}
 	return nil
 }
+
+func validateExpiresOn(expiresOn string) error {
+	expiresOn = strings.TrimSpace(expiresOn)
+	if expiresOn == "" {
+		return nil
+	}
+	_, err := time.Parse(time.DateOnly, expiresOn)
+	if err != nil {
+		return errors.New("date must be in the format YYYY-MM-DD")
+	}
+	return nil
+}
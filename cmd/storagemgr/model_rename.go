@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/NTUEEECluster/storaged"
+)
+
+func NewRenameModel(hostURL string) formModel {
+	m := newFormModel(
+		[]formField{
+			{Header: "Folder Name to Rename", Input: newInput(22, 20, "ExampleProj1", storaged.ValidateProjectName)},
+			{Header: "Storage Tier", Input: newInput(20, 15, "hdd", validateTierName)},
+			{Header: "New Folder Name", Input: newInput(22, 20, "ExampleProj2", storaged.ValidateProjectName)},
+		},
+		func(values []string) webRequestModel {
+			return newRenameRequest(hostURL, values[0], values[1], values[2])
+		},
+	)
+	m.Check = func(values []string) (int, error) {
+		if values[0] == values[2] {
+			return 2, errors.New("new name must be different from the current name")
+		}
+		return 0, nil
+	}
+	return m
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/NTUEEECluster/storaged"
+)
+
+func NewRestoreModel(hostURL string) formModel {
+	return newFormModel(
+		[]formField{
+			{
+				Header: "Deleted Folder Name to Restore",
+				Input:  newInput(22, 20, "ExampleProj1", storaged.ValidateProjectName),
+			},
+			{Header: "Storage Tier", Input: newInput(20, 15, "hdd", validateTierName)},
+			{Header: "ID", Input: newInput(10, 8, "optional", validateDeletedID), Inline: true},
+		},
+		func(values []string) webRequestModel {
+			return newRestoreRequest(hostURL, values[0], values[1], values[2])
+		},
+	)
+}
+
+func validateDeletedID(id string) error {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return nil
+	}
+	if len(id) != 8 || strings.Trim(id, "0123456789abcdef") != "" {
+		return errors.New("ID must be the 8 characters shown by the server")
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/NTUEEECluster/storaged"
+)
+
+func NewSnapshotsModel(hostURL string) formModel {
+	return newFormModel(
+		[]formField{
+			{Header: "Folder Name", Input: newInput(22, 20, "ExampleProj1", storaged.ValidateProjectName)},
+			{Header: "Storage Tier", Input: newInput(20, 15, "hdd", validateTierName)},
+		},
+		func(values []string) webRequestModel {
+			return newSnapshotsRequest(hostURL, values[0], values[1])
+		},
+	)
+}
@@ -101,8 +101,8 @@ func (m webRequestModel) View() string {
 		return m.Spinner.View() + " " + m.RequestDesc + "\n"
 	}
 	switch {
-	case m.Response.StatusCode == 200:
-		return OKStyle.Render(m.Response.Body)
+	case m.Response.StatusCode == http.StatusOK, m.Response.StatusCode == http.StatusAccepted:
+		return renderOKBody(m.Response.Body)
 	case m.Response.StatusCode != 0:
 		return fmt.Sprintf(
 			"%s\n\n%s\n",
@@ -165,3 +165,17 @@ func (m webRequestModel) doRequest() tea.Msg {
 		modelID:    m.modelID,
 	}
 }
+
+// renderOKBody renders a successful response, highlighting the lines the server marked as
+// warnings.
+func renderOKBody(body string) string {
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if strings.Contains(line, "⚠") {
+			lines[i] = WarningStyle.Render(line)
+		} else {
+			lines[i] = OKStyle.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
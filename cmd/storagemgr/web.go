@@ -1,6 +1,19 @@
 package main
 
-import "github.com/NTUEEECluster/storaged"
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/NTUEEECluster/storaged"
+)
+
+// newIdempotencyKey returns a key that makes the server apply a mutating request only once, even
+// if it is sent again.
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
 
 func newQuotaRequest(hostURL string, lookupTarget string) webRequestModel {
 	return NewWebRequestModel("Loading quota information...", hostURL+"/quota", storaged.CheckQuotaRequest{
@@ -8,10 +21,98 @@ func newQuotaRequest(hostURL string, lookupTarget string) webRequestModel {
 	})
 }
 
-func newUpdateRequest(hostURL string, projectName string, projectTier string, sizeInGB int) webRequestModel {
-	return NewWebRequestModel("Requesting server to update quota allocation...", hostURL+"/folders", storaged.UpdateRequest{
-		Name:     projectName,
-		Tier:     projectTier,
-		SizeInGB: sizeInGB,
+func newBreakdownRequest(hostURL string, projectName string, projectTier string, depth int) webRequestModel {
+	return NewWebRequestModel("Calculating folder usage...", hostURL+"/folders/breakdown", storaged.BreakdownRequest{
+		Name:  projectName,
+		Tier:  projectTier,
+		Depth: depth,
+	})
+}
+
+func newPurgeRequest(hostURL string, projectName string, projectTier string, confirm string) webRequestModel {
+	return NewWebRequestModel("Requesting server to delete folder permanently...", hostURL+"/folders", storaged.UpdateRequest{
+		Name:           projectName,
+		Tier:           projectTier,
+		SizeInGB:       0,
+		Permanent:      true,
+		Confirm:        confirm,
+		IdempotencyKey: newIdempotencyKey(),
+	})
+}
+
+func newJobsRequest(hostURL string) webRequestModel {
+	return NewWebRequestModel("Loading recent operations...", hostURL+"/jobs", storaged.JobRequest{})
+}
+
+func newCancelJobRequest(hostURL string, id string) webRequestModel {
+	return NewWebRequestModel("Requesting server to cancel operation...", hostURL+"/jobs/"+id+"/cancel", struct{}{})
+}
+
+func newApprovalsRequest(hostURL string) webRequestModel {
+	return NewWebRequestModel("Loading pending requests...", hostURL+"/approvals", storaged.ApprovalRequest{
+		Action: "list",
+	})
+}
+
+func newDecideRequest(hostURL string, id string, decision string, reason string) webRequestModel {
+	return NewWebRequestModel("Sending decision to server...", hostURL+"/approvals", storaged.ApprovalRequest{
+		Action:         decision,
+		ID:             id,
+		Reason:         reason,
+		IdempotencyKey: newIdempotencyKey(),
+	})
+}
+
+func newRestoreRequest(hostURL string, projectName string, projectTier string, id string) webRequestModel {
+	return NewWebRequestModel("Requesting server to restore folder...", hostURL+"/folders/restore", storaged.RestoreRequest{
+		Name:           projectName,
+		Tier:           projectTier,
+		ID:             id,
+		IdempotencyKey: newIdempotencyKey(),
+	})
+}
+
+func newRenameRequest(hostURL string, projectName string, projectTier string, newName string) webRequestModel {
+	return NewWebRequestModel("Requesting server to rename folder...", hostURL+"/folders/rename", storaged.RenameRequest{
+		Name:           projectName,
+		Tier:           projectTier,
+		NewName:        newName,
+		IdempotencyKey: newIdempotencyKey(),
+	})
+}
+
+func newSnapshotsRequest(hostURL string, projectName string, projectTier string) webRequestModel {
+	return NewWebRequestModel("Listing folder snapshots...", hostURL+"/folders/snapshots", storaged.SnapshotRequest{
+		Action: "list",
+		Name:   projectName,
+		Tier:   projectTier,
+	})
+}
+
+func newMigrateRequest(hostURL string, projectName string, projectTier string, destinationTier string) webRequestModel {
+	return NewWebRequestModel("Requesting server to migrate folder...", hostURL+"/folders/migrate", storaged.MigrateRequest{
+		Name:            projectName,
+		Tier:            projectTier,
+		DestinationTier: destinationTier,
+		IdempotencyKey:  newIdempotencyKey(),
+	})
+}
+
+func newUpdateRequest(
+	hostURL string, projectName string, projectTier string, sizeInGB int, pool string, expiresOn string,
+	dryRun bool,
+) webRequestModel {
+	desc := "Requesting server to update quota allocation..."
+	if dryRun {
+		desc = "Checking quota update with server..."
+	}
+	return NewWebRequestModel(desc, hostURL+"/folders", storaged.UpdateRequest{
+		Name:           projectName,
+		Tier:           projectTier,
+		SizeInGB:       sizeInGB,
+		Pool:           pool,
+		ExpiresOn:      expiresOn,
+		DryRun:         dryRun,
+		IdempotencyKey: newIdempotencyKey(),
 	})
 }
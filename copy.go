@@ -0,0 +1,287 @@
+package storaged
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Files are copied as root inside folders that users can modify while the copy is running. To
+// stop a user from swapping a directory for a symlink and redirecting us outside of their folder,
+// every file is opened relative to its parent directory's descriptor with O_NOFOLLOW.
+const (
+	openDirFlags  = unix.O_RDONLY | unix.O_DIRECTORY | unix.O_NOFOLLOW | unix.O_CLOEXEC
+	openReadFlags = unix.O_RDONLY | unix.O_NOFOLLOW | unix.O_CLOEXEC
+	createFlags   = unix.O_WRONLY | unix.O_CREAT | unix.O_EXCL | unix.O_NOFOLLOW | unix.O_CLOEXEC
+)
+
+// copyTree copies the contents of the directory src into the existing directory dst, preserving
+// ownership, permissions and modification times. Regular files, directories and symlinks are
+// copied while other kinds of files are skipped. progress is called with the number of bytes copied
+// so far. It stops with ctx's error once ctx is cancelled.
+func copyTree(ctx context.Context, src, dst string, progress func(copied int)) error {
+	srcFd, err := unix.Open(src, openDirFlags, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", src, err)
+	}
+	defer func() { _ = unix.Close(srcFd) }()
+	dstFd, err := unix.Open(dst, openDirFlags, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", dst, err)
+	}
+	defer func() { _ = unix.Close(dstFd) }()
+	c := &treeCopier{ctx: ctx, progress: progress}
+	err = c.copyContents(srcFd, dstFd, src)
+	if err != nil {
+		return err
+	}
+	var stat unix.Stat_t
+	err = unix.Fstat(srcFd, &stat)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", src, err)
+	}
+	return applyAttributes(dstFd, &stat, dst)
+}
+
+// copyInto creates the directory name inside dst owned by uid and gid, then copies the entry at
+// the relative path rel inside src into it. If the entry is a directory, its contents are copied
+// instead. None of the directories leading to the entry may be symlinks.
+func copyInto(
+	ctx context.Context, src, rel, dst, name string, uid, gid int, progress func(copied int),
+) error {
+	srcFd, err := unix.Open(src, openDirFlags, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", src, err)
+	}
+	defer func() { _ = unix.Close(srcFd) }()
+	parentFd := srcFd
+	rel = filepath.Clean(rel)
+	base := rel
+	if rel != "." {
+		dirs := strings.Split(rel, "/")
+		base = dirs[len(dirs)-1]
+		for _, dir := range dirs[:len(dirs)-1] {
+			fd, err := unix.Openat(parentFd, dir, openDirFlags, 0)
+			if err != nil {
+				return fmt.Errorf("error opening %s: %w", dir, err)
+			}
+			defer func() { _ = unix.Close(fd) }()
+			parentFd = fd
+		}
+	}
+
+	dstParentFd, err := unix.Open(dst, openDirFlags, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", dst, err)
+	}
+	defer func() { _ = unix.Close(dstParentFd) }()
+	err = unix.Mkdirat(dstParentFd, name, 0o700)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", name, err)
+	}
+	dstFd, err := unix.Openat(dstParentFd, name, openDirFlags, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", name, err)
+	}
+	defer func() { _ = unix.Close(dstFd) }()
+
+	c := &treeCopier{ctx: ctx, progress: progress}
+	err = c.copyEntryContents(parentFd, dstFd, base, filepath.Join(src, rel))
+	if err != nil {
+		return err
+	}
+	err = unix.Fchown(dstFd, uid, gid)
+	if err != nil {
+		return fmt.Errorf("error setting ownership of %s: %w", name, err)
+	}
+	// Chown clears setgid, so the mode is only applied afterwards.
+	err = unix.Fchmod(dstFd, 0o770|unix.S_ISGID)
+	if err != nil {
+		return fmt.Errorf("error setting permissions of %s: %w", name, err)
+	}
+	return nil
+}
+
+type treeCopier struct {
+	ctx      context.Context
+	progress func(copied int)
+	copied   int
+}
+
+// copyEntryContents copies the entry name in the directory srcDirFd into the directory dstFd, or
+// its contents if it is a directory. A name of "." copies the contents of srcDirFd itself.
+func (c *treeCopier) copyEntryContents(srcDirFd, dstFd int, name, srcPath string) error {
+	var stat unix.Stat_t
+	err := unix.Fstatat(srcDirFd, name, &stat, unix.AT_SYMLINK_NOFOLLOW)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", srcPath, err)
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFDIR {
+		return c.copyEntry(srcDirFd, dstFd, name, srcPath)
+	}
+	srcFd, err := unix.Openat(srcDirFd, name, openDirFlags, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", srcPath, err)
+	}
+	defer func() { _ = unix.Close(srcFd) }()
+	return c.copyContents(srcFd, dstFd, srcPath)
+}
+
+// copyContents copies every entry of the directory srcFd into the directory dstFd. srcPath is only
+// used for messages.
+func (c *treeCopier) copyContents(srcFd, dstFd int, srcPath string) error {
+	names, err := readDirNames(srcFd, srcPath)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err := c.copyEntry(srcFd, dstFd, name, filepath.Join(srcPath, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyEntry copies the entry name in the directory srcDirFd to the directory dstDirFd.
+func (c *treeCopier) copyEntry(srcDirFd, dstDirFd int, name, srcPath string) error {
+	if c.ctx.Err() != nil {
+		return c.ctx.Err()
+	}
+	var stat unix.Stat_t
+	err := unix.Fstatat(srcDirFd, name, &stat, unix.AT_SYMLINK_NOFOLLOW)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", srcPath, err)
+	}
+	switch stat.Mode & unix.S_IFMT {
+	case unix.S_IFDIR:
+		srcFd, err := unix.Openat(srcDirFd, name, openDirFlags, 0)
+		if err != nil {
+			return fmt.Errorf("error opening %s: %w", srcPath, err)
+		}
+		defer func() { _ = unix.Close(srcFd) }()
+		// Only root can enter the directory until its contents have been copied.
+		err = unix.Mkdirat(dstDirFd, name, 0o700)
+		if err != nil {
+			return fmt.Errorf("error creating directory for %s: %w", srcPath, err)
+		}
+		dstFd, err := unix.Openat(dstDirFd, name, openDirFlags, 0)
+		if err != nil {
+			return fmt.Errorf("error opening directory for %s: %w", srcPath, err)
+		}
+		defer func() { _ = unix.Close(dstFd) }()
+		err = c.copyContents(srcFd, dstFd, srcPath)
+		if err != nil {
+			return err
+		}
+		// Directory modification times change as we fill them, so they are set last.
+		return applyAttributes(dstFd, &stat, srcPath)
+	case unix.S_IFLNK:
+		target := make([]byte, unix.PathMax)
+		n, err := unix.Readlinkat(srcDirFd, name, target)
+		if err != nil {
+			return fmt.Errorf("error reading symlink %s: %w", srcPath, err)
+		}
+		err = unix.Symlinkat(string(target[:n]), dstDirFd, name)
+		if err != nil {
+			return fmt.Errorf("error creating symlink for %s: %w", srcPath, err)
+		}
+		err = unix.Fchownat(dstDirFd, name, int(stat.Uid), int(stat.Gid), unix.AT_SYMLINK_NOFOLLOW)
+		if err != nil {
+			return fmt.Errorf("error setting ownership of symlink for %s: %w", srcPath, err)
+		}
+		return nil
+	case unix.S_IFREG:
+		return c.copyFile(srcDirFd, dstDirFd, name, srcPath, &stat)
+	default:
+		log.Printf("skipping special file %s while copying", srcPath)
+		return nil
+	}
+}
+
+func (c *treeCopier) copyFile(srcDirFd, dstDirFd int, name, srcPath string, stat *unix.Stat_t) error {
+	srcFd, err := unix.Openat(srcDirFd, name, openReadFlags, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", srcPath, err)
+	}
+	srcFile := os.NewFile(uintptr(srcFd), srcPath)
+	defer func() { _ = srcFile.Close() }()
+	dstFd, err := unix.Openat(dstDirFd, name, createFlags, 0o600)
+	if err != nil {
+		return fmt.Errorf("error creating copy of %s: %w", srcPath, err)
+	}
+	dstFile := os.NewFile(uintptr(dstFd), srcPath)
+	n, err := io.Copy(dstFile, srcFile)
+	c.copied += int(n)
+	if err == nil {
+		err = applyAttributes(dstFd, stat, srcPath)
+	}
+	err = errors.Join(err, dstFile.Close())
+	if err != nil {
+		return fmt.Errorf("error copying %s: %w", srcPath, err)
+	}
+	c.progress(c.copied)
+	return nil
+}
+
+// readDirNames returns the names of the entries in the directory fd. dirPath is only used for
+// messages.
+func readDirNames(fd int, dirPath string) ([]string, error) {
+	// Closing the directory closes its descriptor, so give it one of its own.
+	dupFd, err := unix.Dup(fd)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", dirPath, err)
+	}
+	dir := os.NewFile(uintptr(dupFd), dirPath)
+	defer func() { _ = dir.Close() }()
+	_, err = unix.Seek(dupFd, 0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", dirPath, err)
+	}
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", dirPath, err)
+	}
+	return names, nil
+}
+
+// applyAttributes applies the ownership, permissions and modification time in stat to fd.
+func applyAttributes(fd int, stat *unix.Stat_t, srcPath string) error {
+	err := unix.Fchown(fd, int(stat.Uid), int(stat.Gid))
+	if err != nil {
+		return fmt.Errorf("error setting ownership of copy of %s: %w", srcPath, err)
+	}
+	// Chown clears setuid and setgid bits, so the mode is only applied afterwards.
+	err = unix.Fchmod(fd, stat.Mode&^unix.S_IFMT)
+	if err != nil {
+		return fmt.Errorf("error setting permissions of copy of %s: %w", srcPath, err)
+	}
+	times := []unix.Timeval{unix.NsecToTimeval(stat.Atim.Nano()), unix.NsecToTimeval(stat.Mtim.Nano())}
+	err = unix.Futimes(fd, times)
+	if err != nil {
+		return fmt.Errorf("error setting modification time of copy of %s: %w", srcPath, err)
+	}
+	return nil
+}
+
+// clearTree removes everything inside the directory dir while keeping dir itself.
+func clearTree(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		err := os.RemoveAll(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("error removing %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package storaged
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// MetadataExpires is the metadata key holding when a folder expires and is moved to the trash.
+	MetadataExpires = "expires"
+	// MetadataExpiryWarned holds the expiry the owner of a folder was last warned about, so that
+	// they are warned again if the folder is extended and approaches its new expiry.
+	MetadataExpiryWarned = "expiry_warned"
+	// DefaultExpiryWarning is how long before a folder expires its owner is warned by default.
+	DefaultExpiryWarning = 7 * 24 * time.Hour
+)
+
+// ExpiryPolicy controls the expiry dates of the folders in a tier.
+type ExpiryPolicy struct {
+	// Scratch requires every folder created in the tier to have an expiry date.
+	Scratch bool `toml:"scratch"`
+	// MaxLifetime is how far in the future an expiry date may be set. If zero, there is no limit.
+	MaxLifetime time.Duration `toml:"max_lifetime"`
+	// Warning is how long before a folder expires its owner is warned.
+	Warning time.Duration `toml:"warning"`
+}
+
+// Validate returns an error if the policy cannot be applied.
+func (p ExpiryPolicy) Validate() error {
+	if p.MaxLifetime < 0 || p.Warning < 0 {
+		return errors.New("expiry durations cannot be negative")
+	}
+	return nil
+}
+
+func (p ExpiryPolicy) warning() time.Duration {
+	return cmp.Or(p.Warning, DefaultExpiryWarning)
+}
+
+// parseExpiresOn returns when a folder whose last day is expiresOn (YYYY-MM-DD) expires.
+func parseExpiresOn(expiresOn string, now time.Time) (time.Time, error) {
+	lastDay, err := time.ParseInLocation(time.DateOnly, expiresOn, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry date: %w", err)
+	}
+	// The folder is kept for the entirety of the last day.
+	expires := lastDay.AddDate(0, 0, 1)
+	if !expires.After(now) {
+		return time.Time{}, errors.New("expiry date is in the past")
+	}
+	return expires, nil
+}
+
+// describeExpiry returns the last day a folder expiring at expires is kept.
+func describeExpiry(expires time.Time) string {
+	return expires.Add(-time.Second).Format(time.DateOnly)
+}
+
+// checkExpiry returns a message explaining why expires is not allowed in the tier, or the empty
+// string if it is. creating is set if the folder does not exist yet.
+func (s *Server) checkExpiry(tierName string, expires time.Time, creating bool, now time.Time) string {
+	policy := s.ExpiryPolicies[tierName]
+	if expires.IsZero() {
+		if creating && policy.Scratch {
+			return "Folders in " + tierName + " must have an expiry date."
+		}
+		return ""
+	}
+	if policy.MaxLifetime > 0 && expires.Sub(now) > policy.MaxLifetime {
+		return fmt.Sprintf(
+			"Folders in %s cannot be kept later than %s.",
+			tierName, describeExpiry(now.Add(policy.MaxLifetime)),
+		)
+	}
+	return ""
+}
+
+// formatExpiry returns expires as stored in the metadata of a folder.
+func formatExpiry(expires time.Time) string {
+	if expires.IsZero() {
+		return ""
+	}
+	return expires.Format(time.RFC3339)
+}
+
+// folderExpiry returns when the folder expires, or the zero time if it does not.
+func folderExpiry(quotaFS QuotaFS, name string) (time.Time, error) {
+	expires, err := quotaFS.Metadata(name, MetadataExpires)
+	if err != nil || expires == "" {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, expires)
+}
+
+// expireFolders warns the owners of folders that are about to expire and moves expired folders to
+// the trash.
+func (s *Server) expireFolders() {
+	now := time.Now()
+	for tierName, quotaFS := range s.Tiers {
+		entries, _, err := s.folders(context.Background(), tierName, func(_, _ string) bool { return true })
+		if err != nil {
+			log.Printf("failed to list folders to expire in %s: %v", tierName, err)
+			continue
+		}
+		warning := s.ExpiryPolicies[tierName].warning()
+		for _, entry := range entries {
+			if _, ok := parseTrashPath(entry.Name); ok || entry.Expires.IsZero() {
+				continue
+			}
+			if !now.Before(entry.Expires) {
+				s.expireFolder(tierName, entry.Name, now)
+				continue
+			}
+			if entry.Expires.Sub(now) > warning || entry.Owner == "" {
+				continue
+			}
+			expires := formatExpiry(entry.Expires)
+			warned, err := quotaFS.Metadata(entry.Name, MetadataExpiryWarned)
+			if err != nil || warned == expires {
+				continue
+			}
+			s.notify(Event{
+				Kind:     EventFolderExpiring,
+				User:     entry.Owner,
+				Tier:     tierName,
+				Folder:   entry.Name,
+				Bytes:    entry.Usage,
+				Deadline: entry.Expires,
+				Message: fmt.Sprintf(
+					"Your folder %s in %s expires after %s and will then be moved to the trash. "+
+						"Copy out anything you need or extend its expiry date.",
+					entry.Name, tierName, describeExpiry(entry.Expires),
+				),
+			})
+			err = quotaFS.SetMetadata(entry.Name, MetadataExpiryWarned, expires)
+			if err != nil {
+				log.Printf("failed to record expiry warning for %s in %s: %v", entry.Name, tierName, err)
+			}
+		}
+	}
+}
+
+// expireFolder moves the folder to the trash if it is still expired.
+func (s *Server) expireFolder(tierName, name string, now time.Time) {
+	quotaFS := s.Tiers[tierName]
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+	defer s.refreshFolder(tierName, name)
+	// The index may be stale, so check that the folder has not been extended in the meantime.
+	expires, err := folderExpiry(quotaFS, name)
+	if err != nil || expires.IsZero() || now.Before(expires) {
+		return
+	}
+	migration, err := quotaFS.Metadata(name, MetadataMigration)
+	if err != nil || migration != "" || s.jobs.Active(tierName, name) {
+		// Try again once whatever is happening to the folder has finished.
+		return
+	}
+	owner, err := quotaFS.FileOwner(name)
+	if err != nil && !errors.As(err, new(*UnknownOwnerError)) {
+		log.Printf("failed to fetch owner of expired folder %s in %s: %v", name, tierName, err)
+		return
+	}
+	usage, err := quotaFS.Usage(name)
+	if err != nil {
+		usage = 0
+	}
+	_, purgeAt, err := s.moveToTrash(tierName, name)
+	if err != nil {
+		log.Printf("failed to move expired folder %s in %s to the trash: %v", name, tierName, err)
+		return
+	}
+	err = s.ProjectFS.DeleteLink(name)
+	if err != nil {
+		log.Printf("failed to delete link of expired folder %s: %v", name, err)
+	}
+	log.Printf("moved expired folder %s in %s to the trash", name, tierName)
+	if owner == "" {
+		return
+	}
+	s.notify(Event{
+		Kind:   EventFolderExpired,
+		User:   owner,
+		Tier:   tierName,
+		Folder: name,
+		Bytes:  usage,
+		Message: fmt.Sprintf(
+			"Your folder %s in %s has expired and has been moved to the trash. "+
+				"It can be restored until %s.",
+			name, tierName, purgeAt.Format(time.DateTime),
+		),
+	})
+}
@@ -0,0 +1,143 @@
+package storaged
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// DefaultForecastWindow is how much recent history is used to fit the growth of a folder by
+	// default.
+	DefaultForecastWindow = 14 * 24 * time.Hour
+	// DefaultForecastHorizon is how soon a folder has to be predicted to fill up by default before
+	// it is highlighted.
+	DefaultForecastHorizon = 30 * 24 * time.Hour
+)
+
+// minForecastSpan is the minimum span of history needed before a forecast is attempted.
+const minForecastSpan = 24 * time.Hour
+
+type ForecastModel string
+
+const (
+	// ForecastModelLinear fits usage growing by a constant amount every day. It is the default.
+	ForecastModelLinear ForecastModel = "linear"
+	// ForecastModelExponential fits usage growing by a constant factor every day.
+	ForecastModelExponential ForecastModel = "exponential"
+)
+
+// Validate returns an error if the model is not known.
+func (m ForecastModel) Validate() error {
+	switch m {
+	case "", ForecastModelLinear, ForecastModelExponential:
+		return nil
+	default:
+		return fmt.Errorf("unknown forecast model %q", m)
+	}
+}
+
+// forecast is the predicted growth of a folder.
+type forecast struct {
+	// BytesPerDay is the current growth rate.
+	BytesPerDay float64
+	// TimeToFull is how long until usage reaches the quota, or negative if it never will.
+	TimeToFull time.Duration
+}
+
+// Describe returns a short human-readable description of the forecast.
+func (f forecast) Describe() string {
+	growth := "+" + FormatByteSize(int(math.Abs(f.BytesPerDay))) + "/day"
+	if f.BytesPerDay < 0 {
+		growth = "-" + FormatByteSize(int(math.Abs(f.BytesPerDay))) + "/day"
+	}
+	if f.TimeToFull < 0 {
+		return growth
+	}
+	return fmt.Sprintf("%s, full in ~%d days", growth, int(f.TimeToFull.Hours()/24))
+}
+
+// forecastFolder predicts when the folder fills up from its recorded usage history. It returns
+// false if there is not enough history to make a prediction.
+func (s *Server) forecastFolder(tier string, folder Quota, now time.Time) (forecast, bool) {
+	if folder.Quota >= QuotaUnbounded {
+		return forecast{}, false
+	}
+	since := now.Add(-cmp.Or(s.ForecastWindow, DefaultForecastWindow))
+	points := s.history.Series(func(sample historySample) bool {
+		return sample.Tier == tier && sample.Folder == folder.Name && sample.Time.After(since)
+	})
+	if len(points) < 3 || points[len(points)-1].Time.Sub(points[0].Time) < minForecastSpan {
+		return forecast{}, false
+	}
+	switch s.ForecastModel {
+	case ForecastModelExponential:
+		return fitExponential(points, folder.Usage, folder.Quota)
+	default:
+		return fitLinear(points, folder.Usage, folder.Quota)
+	}
+}
+
+// fitLinear fits usage = a + b*days with least squares.
+func fitLinear(points []HistoryPoint, usage, quota int) (forecast, bool) {
+	slope, ok := leastSquaresSlope(points, func(p HistoryPoint) (float64, bool) {
+		return float64(p.Usage), true
+	})
+	if !ok {
+		return forecast{}, false
+	}
+	result := forecast{BytesPerDay: slope, TimeToFull: -1}
+	if slope > 0 {
+		result.TimeToFull = daysToDuration(float64(max(quota-usage, 0)) / slope)
+	}
+	return result, true
+}
+
+// fitExponential fits ln(usage) = a + r*days with least squares.
+func fitExponential(points []HistoryPoint, usage, quota int) (forecast, bool) {
+	if usage <= 0 {
+		return forecast{}, false
+	}
+	rate, ok := leastSquaresSlope(points, func(p HistoryPoint) (float64, bool) {
+		return math.Log(float64(p.Usage)), p.Usage > 0
+	})
+	if !ok {
+		return forecast{}, false
+	}
+	result := forecast{BytesPerDay: float64(usage) * (math.Exp(rate) - 1), TimeToFull: -1}
+	if rate > 0 {
+		result.TimeToFull = daysToDuration(math.Max(math.Log(float64(quota)/float64(usage)), 0) / rate)
+	}
+	return result, true
+}
+
+// leastSquaresSlope returns the slope of the least squares fit of value against time in days.
+// Points for which value returns false are skipped.
+func leastSquaresSlope(points []HistoryPoint, value func(HistoryPoint) (float64, bool)) (float64, bool) {
+	var n, sumX, sumY, sumXX, sumXY float64
+	origin := points[0].Time
+	for _, p := range points {
+		y, ok := value(p)
+		if !ok {
+			continue
+		}
+		x := p.Time.Sub(origin).Hours() / 24
+		n++
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+	denominator := n*sumXX - sumX*sumX
+	if n < 2 || denominator == 0 {
+		return 0, false
+	}
+	return (n*sumXY - sumX*sumY) / denominator, true
+}
+
+func daysToDuration(days float64) time.Duration {
+	// Cap to avoid overflowing time.Duration for folders that grow extremely slowly.
+	const maxDays = 100 * 365
+	return time.Duration(math.Min(days, maxDays) * float64(24*time.Hour))
+}
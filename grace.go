@@ -0,0 +1,299 @@
+package storaged
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/user"
+	"slices"
+	"sync"
+	"time"
+)
+
+const graceStateFile = "grace.json"
+
+// DefaultGracePeriod is how long users may stay over their allocation by default before the
+// GracePolicy is enforced.
+const DefaultGracePeriod = 14 * 24 * time.Hour
+
+type GracePolicy string
+
+const (
+	// GracePolicyNone only notifies the user and blocks further growth. It is the default.
+	GracePolicyNone GracePolicy = "none"
+	// GracePolicyFreeze sets the quota of every folder to its current usage after the grace
+	// period so that it cannot grow any further.
+	GracePolicyFreeze GracePolicy = "freeze"
+	// GracePolicyShrink reduces the quota of folders, starting from the one with the most free
+	// space, until the user fits in their allocation. Quotas are never reduced below usage.
+	GracePolicyShrink GracePolicy = "shrink"
+)
+
+// Validate returns an error if the policy is not known.
+func (p GracePolicy) Validate() error {
+	switch p {
+	case "", GracePolicyNone, GracePolicyFreeze, GracePolicyShrink:
+		return nil
+	default:
+		return fmt.Errorf("unknown grace policy %q", p)
+	}
+}
+
+// graceRecord tracks a user that has been assigned more quota than they are allocated in a tier.
+type graceRecord struct {
+	User     string    `json:"user"`
+	Tier     string    `json:"tier"`
+	Since    time.Time `json:"since"`
+	Deadline time.Time `json:"deadline"`
+	// Enforced is set once the GracePolicy has been applied.
+	Enforced bool `json:"enforced,omitempty"`
+}
+
+// Describe returns a human-readable countdown of the grace period.
+func (r graceRecord) Describe(now time.Time) string {
+	if r.Enforced {
+		return "Over allocation: the grace period ended on " + r.Deadline.Format(time.DateOnly) + "."
+	}
+	remaining := r.Deadline.Sub(now)
+	return fmt.Sprintf(
+		"Over allocation: shrink or delete folders within %d days (by %s).",
+		int(remaining.Hours()/24), r.Deadline.Format(time.DateTime),
+	)
+}
+
+type graceStore struct {
+	mutex   sync.Mutex
+	path    string
+	records map[string]graceRecord
+}
+
+func graceKey(userName, tier string) string {
+	return tier + "/" + userName
+}
+
+func loadGraceStore(statePath string) (*graceStore, error) {
+	store := &graceStore{path: statePath, records: make(map[string]graceRecord)}
+	err := loadState(statePath, &store.records)
+	if err != nil {
+		return nil, fmt.Errorf("error loading grace periods: %w", err)
+	}
+	return store, nil
+}
+
+// Get returns the record for the user in the tier, if any.
+func (g *graceStore) Get(userName, tier string) (graceRecord, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	record, ok := g.records[graceKey(userName, tier)]
+	return record, ok
+}
+
+// Update replaces the records of the tier with records.
+func (g *graceStore) Update(tier string, records []graceRecord) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	updated := make(map[string]graceRecord, len(g.records))
+	for k, v := range g.records {
+		if v.Tier != tier {
+			updated[k] = v
+		}
+	}
+	for _, record := range records {
+		updated[graceKey(record.User, record.Tier)] = record
+	}
+	err := saveState(g.path, updated)
+	if err != nil {
+		return fmt.Errorf("error saving grace periods: %w", err)
+	}
+	g.records = updated
+	return nil
+}
+
+// checkGracePeriods finds users who are assigned more quota than they are allocated, starts grace
+// periods for them and enforces the GracePolicy on those whose grace period has ended. Folders
+// charged to pools are not considered.
+func (s *Server) checkGracePeriods() {
+	for tierName := range s.Tiers {
+		entries, _, err := s.folders(context.Background(), tierName, func(_, pool string) bool { return pool == "" })
+		if err != nil {
+			log.Printf("failed to check grace periods in %s: %v", tierName, err)
+			continue
+		}
+		byOwner := make(map[string][]Quota)
+		for _, entry := range entries {
+			if entry.Owner == "" {
+				// Orphaned folders are handled by administrators instead.
+				continue
+			}
+			byOwner[entry.Owner] = append(byOwner[entry.Owner], entry)
+		}
+		var records []graceRecord
+		for owner, ownerEntries := range byOwner {
+			record, ok := s.checkGracePeriod(tierName, owner, ownerEntries)
+			if ok {
+				records = append(records, record)
+			}
+		}
+		err = s.grace.Update(tierName, records)
+		if err != nil {
+			log.Printf("failed to check grace periods in %s: %v", tierName, err)
+		}
+	}
+}
+
+// checkGracePeriod returns the grace record for the owner in the tier, or false if they are
+// within their allocation.
+func (s *Server) checkGracePeriod(tierName, owner string, entries []Quota) (graceRecord, bool) {
+	ownerUser, err := user.Lookup(owner)
+	if err != nil {
+		log.Printf("failed to look up %s to check grace period: %v", owner, err)
+		record, ok := s.grace.Get(owner, tierName)
+		return record, ok
+	}
+	allowed, err := s.allowedQuota(ownerUser)
+	if err != nil {
+		log.Printf("failed to calculate quota allocated to %s: %v", owner, err)
+		record, ok := s.grace.Get(owner, tierName)
+		return record, ok
+	}
+	used := 0
+	for _, entry := range entries {
+		used += entry.Quota
+	}
+	if used <= allowed[tierName] {
+		return graceRecord{}, false
+	}
+	now := time.Now()
+	record, ok := s.grace.Get(owner, tierName)
+	if !ok {
+		gracePeriod := cmp.Or(s.GracePeriod, DefaultGracePeriod)
+		record = graceRecord{
+			User:     owner,
+			Tier:     tierName,
+			Since:    now,
+			Deadline: now.Add(gracePeriod),
+		}
+		s.notify(Event{
+			Kind:     EventOverAllocated,
+			User:     owner,
+			Tier:     tierName,
+			Bytes:    used - allowed[tierName],
+			Deadline: record.Deadline,
+			Message: fmt.Sprintf(
+				"You have been assigned %s in %s but are only allocated %s. "+
+					"Please shrink or delete folders before %s.",
+				FormatByteSize(used), tierName, FormatByteSize(allowed[tierName]),
+				record.Deadline.Format(time.DateTime),
+			),
+		})
+	}
+	if record.Enforced || now.Before(record.Deadline) {
+		return record, true
+	}
+	if s.GracePolicy != GracePolicyFreeze && s.GracePolicy != GracePolicyShrink {
+		// Further growth is already blocked, so there is nothing else to enforce.
+		record.Enforced = true
+		return record, true
+	}
+	excess, err := s.enforceGracePolicy(tierName, owner, allowed[tierName])
+	switch {
+	case err != nil:
+		// The record stays unenforced so that enforcement is retried on the next check.
+		log.Printf("failed to enforce grace policy on %s in %s: %v", owner, tierName, err)
+		return record, true
+	case excess <= 0:
+		// The owner shrank their folders after they were scanned.
+		return graceRecord{}, false
+	}
+	record.Enforced = true
+	s.notify(Event{
+		Kind:  EventGraceExpired,
+		User:  owner,
+		Tier:  tierName,
+		Bytes: excess,
+		Message: fmt.Sprintf(
+			"Your grace period for exceeding your allocation in %s has ended. "+
+				"The quota of your folders has been reduced.",
+			tierName,
+		),
+	})
+	return record, true
+}
+
+// enforceGracePolicy applies the GracePolicy to the folders of the owner in the tier and returns
+// by how much they exceeded their allocation. The folders are read again under updateMutex so
+// that folders resized since the scan are not shrunk based on stale quotas.
+func (s *Server) enforceGracePolicy(tierName, owner string, allowed int) (excess int, err error) {
+	quotaFS := s.Tiers[tierName]
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+	entries, _, err := s.quotaUsed(context.Background(), tierName, owner)
+	if err != nil {
+		return 0, fmt.Errorf("error listing folders: %w", err)
+	}
+	used := 0
+	for i, entry := range entries {
+		entries[i].Quota, err = quotaFS.Quota(entry.Name)
+		if err != nil {
+			return 0, fmt.Errorf("error fetching quota of %s: %w", entry.Name, err)
+		}
+		entries[i].Usage, err = quotaFS.Usage(entry.Name)
+		if err != nil {
+			return 0, fmt.Errorf("error fetching usage of %s: %w", entry.Name, err)
+		}
+		used += entries[i].Quota
+	}
+	excess = used - allowed
+	if excess <= 0 {
+		return excess, nil
+	}
+	if s.GracePolicy == GracePolicyFreeze {
+		return excess, s.freezeFolders(tierName, entries)
+	}
+	return excess, s.shrinkFolders(tierName, entries, excess)
+}
+
+// freezeFolders sets the quota of every folder to its usage. The caller must hold updateMutex.
+func (s *Server) freezeFolders(tierName string, entries []Quota) error {
+	quotaFS := s.Tiers[tierName]
+	var errs []error
+	for _, entry := range entries {
+		// A quota of zero means unbounded, so never go below a single byte.
+		err := quotaFS.SetQuota(entry.Name, max(entry.Usage, 1))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error freezing %s: %w", entry.Name, err))
+		}
+		s.refreshFolder(tierName, entry.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// shrinkFolders reduces the quota of the folders by up to excess bytes in total without going
+// below their usage. The caller must hold updateMutex.
+func (s *Server) shrinkFolders(tierName string, entries []Quota, excess int) error {
+	quotaFS := s.Tiers[tierName]
+	entries = slices.Clone(entries)
+	slices.SortFunc(entries, func(a, b Quota) int {
+		return (b.Quota - b.Usage) - (a.Quota - a.Usage)
+	})
+	var errs []error
+	for _, entry := range entries {
+		if excess <= 0 {
+			break
+		}
+		reduction := min(excess, entry.Quota-max(entry.Usage, 1))
+		if reduction <= 0 {
+			continue
+		}
+		err := quotaFS.SetQuota(entry.Name, entry.Quota-reduction)
+		s.refreshFolder(tierName, entry.Name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error shrinking %s: %w", entry.Name, err))
+			continue
+		}
+		excess -= reduction
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,212 @@
+package storaged
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+const grantStateFile = "grants.json"
+
+// DefaultGrantExpiryWarning is how long before a grant expires its user is warned by default.
+const DefaultGrantExpiryWarning = 7 * 24 * time.Hour
+
+// Grant is an allocation given to a single user on top of, or instead of, the allocations of their
+// groups.
+type Grant struct {
+	ID   string `json:"id"`
+	User string `json:"user"`
+	Tier string `json:"tier"`
+	// Bytes is the size of the grant.
+	Bytes int `json:"bytes"`
+	// Override replaces the allocations of the user's groups for the tier instead of adding to
+	// them.
+	Override bool `json:"override,omitempty"`
+	// Expires is when the grant stops applying. The zero value means the grant never expires.
+	Expires   time.Time `json:"expires,omitzero"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiryNotified is set once the user has been warned that the grant is expiring.
+	ExpiryNotified bool `json:"expiry_notified,omitempty"`
+}
+
+// Active returns whether the grant applies at the specified time.
+func (g Grant) Active(now time.Time) bool {
+	return g.Expires.IsZero() || now.Before(g.Expires)
+}
+
+// Describe returns a human-readable description of the grant.
+func (g Grant) Describe() string {
+	desc := fmt.Sprintf("+%s on %s", FormatByteSize(g.Bytes), g.Tier)
+	if g.Override {
+		desc = fmt.Sprintf("%s on %s (override)", FormatByteSize(g.Bytes), g.Tier)
+	}
+	if !g.Expires.IsZero() {
+		desc += " until " + g.Expires.Add(-time.Second).Format(time.DateOnly)
+	}
+	return desc
+}
+
+// grantStore is the persistent list of grants.
+type grantStore struct {
+	mutex  sync.Mutex
+	path   string
+	grants []Grant
+}
+
+func loadGrantStore(statePath string) (*grantStore, error) {
+	store := &grantStore{path: statePath}
+	err := loadState(statePath, &store.grants)
+	if err != nil {
+		return nil, fmt.Errorf("error loading grants: %w", err)
+	}
+	return store, nil
+}
+
+// Active returns the grants for the user that apply at the specified time.
+func (g *grantStore) Active(userName string, now time.Time) []Grant {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var result []Grant
+	for _, grant := range g.grants {
+		if grant.User == userName && grant.Active(now) {
+			result = append(result, grant)
+		}
+	}
+	return result
+}
+
+// List returns all grants for the user, or all grants if userName is empty.
+func (g *grantStore) List(userName string) []Grant {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var result []Grant
+	for _, grant := range g.grants {
+		if userName == "" || grant.User == userName {
+			result = append(result, grant)
+		}
+	}
+	return result
+}
+
+// Add stores the grant, assigning it a new ID.
+func (g *grantStore) Add(grant Grant) (Grant, error) {
+	var err error
+	grant.ID, err = randomID()
+	if err != nil {
+		return Grant{}, err
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.grants = append(g.grants, grant)
+	err = saveState(g.path, g.grants)
+	if err != nil {
+		g.grants = g.grants[:len(g.grants)-1]
+		return Grant{}, fmt.Errorf("error saving grants: %w", err)
+	}
+	return grant, nil
+}
+
+// Remove deletes the grant with the specified ID.
+func (g *grantStore) Remove(id string) (Grant, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	idx := slices.IndexFunc(g.grants, func(grant Grant) bool { return grant.ID == id })
+	if idx == -1 {
+		return Grant{}, fmt.Errorf("grant %q does not exist", id)
+	}
+	removed := g.grants[idx]
+	remaining := slices.Delete(slices.Clone(g.grants), idx, idx+1)
+	err := saveState(g.path, remaining)
+	if err != nil {
+		return Grant{}, fmt.Errorf("error saving grants: %w", err)
+	}
+	g.grants = remaining
+	return removed, nil
+}
+
+// Expire removes all grants that have expired and marks the grants expiring within warning as
+// notified. It returns the grants in either category so that their users can be notified.
+func (g *grantStore) Expire(now time.Time, warning time.Duration) (expired, expiring []Grant, err error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	remaining := make([]Grant, 0, len(g.grants))
+	for _, grant := range g.grants {
+		switch {
+		case !grant.Active(now):
+			expired = append(expired, grant)
+			continue
+		case !grant.Expires.IsZero() && !grant.ExpiryNotified && grant.Expires.Sub(now) <= warning:
+			grant.ExpiryNotified = true
+			expiring = append(expiring, grant)
+		}
+		remaining = append(remaining, grant)
+	}
+	if len(expired) == 0 && len(expiring) == 0 {
+		return nil, nil, nil
+	}
+	err = saveState(g.path, remaining)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error saving grants: %w", err)
+	}
+	g.grants = remaining
+	return expired, expiring, nil
+}
+
+// applyGrants adjusts the quota allocated to the user by their groups with their active grants.
+func (s *Server) applyGrants(userName string, quota map[string]int) {
+	grants := s.grants.Active(userName, time.Now())
+	overrideQuota := make(map[string]int)
+	for _, grant := range grants {
+		if grant.Override {
+			overrideQuota[grant.Tier] = max(overrideQuota[grant.Tier], grant.Bytes)
+		}
+	}
+	for tier, override := range overrideQuota {
+		quota[tier] = override
+	}
+	for _, grant := range grants {
+		if !grant.Override {
+			quota[grant.Tier] += grant.Bytes
+		}
+	}
+}
+
+// expireGrants removes expired grants and notifies users of grants that are about to expire.
+func (s *Server) expireGrants() {
+	warning := s.GrantExpiryWarning
+	if warning == 0 {
+		warning = DefaultGrantExpiryWarning
+	}
+	expired, expiring, err := s.grants.Expire(time.Now(), warning)
+	if err != nil {
+		log.Printf("failed to expire grants: %v", err)
+		return
+	}
+	for _, grant := range expiring {
+		s.notify(Event{
+			Kind:     EventGrantExpiring,
+			User:     grant.User,
+			Tier:     grant.Tier,
+			Bytes:    grant.Bytes,
+			Deadline: grant.Expires,
+			Message: fmt.Sprintf(
+				"Your storage grant of %s is about to expire. "+
+					"Folders exceeding your remaining allocation will not be able to grow afterwards.",
+				grant.Describe(),
+			),
+		})
+	}
+	for _, grant := range expired {
+		s.notify(Event{
+			Kind:    EventGrantExpired,
+			User:    grant.User,
+			Tier:    grant.Tier,
+			Bytes:   grant.Bytes,
+			Message: fmt.Sprintf("Your storage grant of %s has expired.", grant.Describe()),
+		})
+	}
+}
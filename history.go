@@ -0,0 +1,280 @@
+package storaged
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const historyStateFile = "history.jsonl"
+
+const (
+	// DefaultHistoryInterval is how often usage samples are recorded by default.
+	DefaultHistoryInterval = time.Hour
+	// DefaultHistoryRawRetention is how long every sample is kept by default before being
+	// downsampled to one per day.
+	DefaultHistoryRawRetention = 7 * 24 * time.Hour
+	// DefaultHistoryRetention is how long samples are kept by default.
+	DefaultHistoryRetention = 365 * 24 * time.Hour
+)
+
+// historySample is the usage and quota of a single folder at a point in time.
+type historySample struct {
+	Time   time.Time `json:"t"`
+	Tier   string    `json:"tier"`
+	Folder string    `json:"folder"`
+	Owner  string    `json:"owner"`
+	Usage  int       `json:"usage"`
+	Quota  int       `json:"quota"`
+}
+
+// historyStore keeps usage samples in memory and appends them to a JSON lines file. The file is
+// rewritten when old samples are downsampled or dropped.
+type historyStore struct {
+	mutex       sync.RWMutex
+	path        string
+	samples     []historySample
+	lastCompact time.Time
+}
+
+func loadHistoryStore(statePath string) (*historyStore, error) {
+	store := &historyStore{path: statePath}
+	if statePath == "" {
+		return store, nil
+	}
+	f, err := os.Open(statePath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return store, nil
+	case err != nil:
+		return nil, fmt.Errorf("error opening usage history: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var sample historySample
+		err := json.Unmarshal(scanner.Bytes(), &sample)
+		if err != nil {
+			// A partially written line from a crash should not lose the whole history.
+			log.Printf("skipping invalid usage history line: %v", err)
+			continue
+		}
+		store.samples = append(store.samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading usage history: %w", err)
+	}
+	return store, nil
+}
+
+// Append records the samples.
+func (h *historyStore) Append(samples []historySample) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.samples = append(h.samples, samples...)
+	if h.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening usage history: %w", err)
+	}
+	return writeSamples(f, samples)
+}
+
+// Compact drops samples older than retention and keeps only the last sample of each day for each
+// folder for samples older than rawRetention. The kept samples are moved to the last time any
+// folder was sampled on that day, so that folders created or deleted during the day are summed up
+// with the others instead of forming points of their own.
+func (h *historyStore) Compact(now time.Time, rawRetention, retention time.Duration) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.lastCompact = now
+	type dayKey struct {
+		tier, folder string
+		day          string
+	}
+	lastOfDay := make(map[dayKey]int)
+	lastSweep := make(map[string]time.Time)
+	for i, sample := range h.samples {
+		if age := now.Sub(sample.Time); age > rawRetention && age <= retention {
+			day := sample.Time.Format(time.DateOnly)
+			lastOfDay[dayKey{sample.Tier, sample.Folder, day}] = i
+			if sample.Time.After(lastSweep[day]) {
+				lastSweep[day] = sample.Time
+			}
+		}
+	}
+	compacted := make([]historySample, 0, len(h.samples))
+	for i, sample := range h.samples {
+		age := now.Sub(sample.Time)
+		switch {
+		case age > retention:
+			continue
+		case age > rawRetention:
+			day := sample.Time.Format(time.DateOnly)
+			if lastOfDay[dayKey{sample.Tier, sample.Folder, day}] != i {
+				continue
+			}
+			sample.Time = lastSweep[day]
+		}
+		compacted = append(compacted, sample)
+	}
+	if h.path != "" {
+		err := h.write(compacted)
+		if err != nil {
+			return err
+		}
+	}
+	h.samples = compacted
+	return nil
+}
+
+// write atomically replaces the history file with the samples.
+func (h *historyStore) write(samples []historySample) error {
+	tmpPath := h.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("error creating usage history: %w", err)
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+	err = writeSamples(f, samples)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, h.path)
+	if err != nil {
+		return fmt.Errorf("error replacing usage history: %w", err)
+	}
+	return nil
+}
+
+// writeSamples writes the samples to f as JSON lines and closes it.
+func writeSamples(f *os.File, samples []historySample) error {
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, sample := range samples {
+		err := encoder.Encode(sample)
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("error writing usage history: %w", err)
+		}
+	}
+	err := w.Flush()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error writing usage history: %w", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("error writing usage history: %w", err)
+	}
+	return nil
+}
+
+// Rename moves the history of a folder to its new name.
+func (h *historyStore) Rename(tier, folder, newFolder string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	renamed := slices.Clone(h.samples)
+	changed := false
+	for i := range renamed {
+		if renamed[i].Tier == tier && renamed[i].Folder == folder {
+			renamed[i].Folder = newFolder
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if h.path != "" {
+		err := h.write(renamed)
+		if err != nil {
+			return err
+		}
+	}
+	h.samples = renamed
+	return nil
+}
+
+// LastCompact returns when the history was last compacted.
+func (h *historyStore) LastCompact() time.Time {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.lastCompact
+}
+
+// Series returns the matching samples summed up for each point in time, in chronological order.
+func (h *historyStore) Series(match func(sample historySample) bool) []HistoryPoint {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	var points []HistoryPoint
+	pointIdx := make(map[time.Time]int)
+	for _, sample := range h.samples {
+		if !match(sample) {
+			continue
+		}
+		idx, ok := pointIdx[sample.Time]
+		if !ok {
+			idx = len(points)
+			pointIdx[sample.Time] = idx
+			points = append(points, HistoryPoint{Time: sample.Time})
+		}
+		points[idx].Usage += sample.Usage
+		points[idx].Quota += sample.Quota
+	}
+	slices.SortFunc(points, func(a, b HistoryPoint) int {
+		return a.Time.Compare(b.Time)
+	})
+	return points
+}
+
+// recordHistory records a sample for every folder and compacts the history once a day.
+func (s *Server) recordHistory() {
+	now := time.Now()
+	var samples []historySample
+	for tierName := range s.Tiers {
+		entries, _, err := s.folders(context.Background(), tierName, func(_, _ string) bool { return true })
+		if err != nil {
+			log.Printf("failed to record usage history of %s: %v", tierName, err)
+			continue
+		}
+		for _, entry := range entries {
+			if _, ok := parseTrashPath(entry.Name); ok {
+				continue
+			}
+			samples = append(samples, historySample{
+				Time:   now,
+				Tier:   tierName,
+				Folder: entry.Name,
+				Owner:  entry.Owner,
+				Usage:  entry.Usage,
+				Quota:  entry.Quota,
+			})
+		}
+	}
+	err := s.history.Append(samples)
+	if err != nil {
+		log.Printf("failed to record usage history: %v", err)
+	}
+	if now.Sub(s.history.LastCompact()) < 24*time.Hour {
+		return
+	}
+	err = s.history.Compact(
+		now,
+		cmp.Or(s.HistoryRawRetention, DefaultHistoryRawRetention),
+		cmp.Or(s.HistoryRetention, DefaultHistoryRetention),
+	)
+	if err != nil {
+		log.Printf("failed to compact usage history: %v", err)
+	}
+}
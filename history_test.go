@@ -0,0 +1,42 @@
+package storaged
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHistoryCompactSeries(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	store, err := loadHistoryStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := func(hour int, folder string, usage int) historySample {
+		return historySample{
+			Time: day.Add(time.Duration(hour) * time.Hour), Tier: "hdd", Folder: folder, Owner: "alice", Usage: usage,
+		}
+	}
+	// b is deleted and c is created during the day, so their last samples of the day are taken
+	// before and after the last sample of a.
+	err = store.Append([]historySample{
+		sample(10, "a", 1), sample(10, "b", 10),
+		sample(15, "a", 2), sample(15, "b", 20),
+		sample(22, "a", 3), sample(22, "c", 100),
+		sample(23, "c", 200),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := day.Add(30 * 24 * time.Hour)
+	err = store.Compact(now, 7*24*time.Hour, 365*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := store.Series(func(historySample) bool { return true })
+	want := []HistoryPoint{{Time: day.Add(23 * time.Hour), Usage: 3 + 20 + 200}}
+	if !slices.Equal(got, want) {
+		t.Errorf("Series() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,216 @@
+package storaged
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyStateFile = "idempotency.json"
+	// DefaultIdempotencyTTL is how long the response to a request with an idempotency key is kept
+	// by default.
+	DefaultIdempotencyTTL = 24 * time.Hour
+	// maxIdempotencyKeyLength is the longest idempotency key accepted.
+	maxIdempotencyKeyLength = 128
+)
+
+var (
+	errIdempotencyKeyReused     = errors.New("idempotency key was used for a different request")
+	errIdempotencyKeyInProgress = errors.New("request with idempotency key is in progress")
+)
+
+// idempotentResponse is the response to a request with an idempotency key.
+type idempotentResponse struct {
+	UID int    `json:"uid"`
+	Key string `json:"key"`
+	// Fingerprint identifies the endpoint and payload of the request, so that a key reused for a
+	// different request is rejected instead of returning an unrelated response.
+	Fingerprint string    `json:"fingerprint"`
+	Status      int       `json:"status"`
+	Body        string    `json:"body"`
+	Created     time.Time `json:"created"`
+}
+
+// idempotencyStore remembers the responses to requests with idempotency keys.
+type idempotencyStore struct {
+	mutex     sync.Mutex
+	path      string
+	ttl       time.Duration
+	responses []idempotentResponse
+	// inProgress holds the keys of requests that are still being handled.
+	inProgress map[idempotencyKey]bool
+}
+
+type idempotencyKey struct {
+	uid int
+	key string
+}
+
+func loadIdempotencyStore(statePath string, ttl time.Duration) (*idempotencyStore, error) {
+	store := &idempotencyStore{
+		path:       statePath,
+		ttl:        cmp.Or(ttl, DefaultIdempotencyTTL),
+		inProgress: make(map[idempotencyKey]bool),
+	}
+	err := loadState(statePath, &store.responses)
+	if err != nil {
+		return nil, fmt.Errorf("error loading idempotent responses: %w", err)
+	}
+	return store, nil
+}
+
+// Begin returns the stored response for the key, or nil if the request should be handled. In the
+// latter case, the key is marked as in progress until Finish is called.
+func (i *idempotencyStore) Begin(uid int, key, fingerprint string, now time.Time) (*idempotentResponse, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.responses = slices.DeleteFunc(i.responses, func(r idempotentResponse) bool {
+		return now.Sub(r.Created) > i.ttl
+	})
+	idx := slices.IndexFunc(i.responses, func(r idempotentResponse) bool {
+		return r.UID == uid && r.Key == key
+	})
+	switch {
+	case idx != -1 && i.responses[idx].Fingerprint != fingerprint:
+		return nil, errIdempotencyKeyReused
+	case idx != -1:
+		response := i.responses[idx]
+		return &response, nil
+	case i.inProgress[idempotencyKey{uid, key}]:
+		return nil, errIdempotencyKeyInProgress
+	}
+	i.inProgress[idempotencyKey{uid, key}] = true
+	return nil, nil
+}
+
+// Finish stores the response to the request with the key. Server errors are not stored so that
+// the request can be retried.
+func (i *idempotencyStore) Finish(response idempotentResponse) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	delete(i.inProgress, idempotencyKey{response.UID, response.Key})
+	if response.Status >= http.StatusInternalServerError {
+		return nil
+	}
+	i.responses = append(i.responses, response)
+	err := saveState(i.path, i.responses)
+	if err != nil {
+		// The response is still remembered until the server restarts.
+		return fmt.Errorf("error saving idempotent responses: %w", err)
+	}
+	return nil
+}
+
+type idempotencySlotKey struct{}
+
+// idempotencySlot is filled in by readRequest if the request has an idempotency key.
+type idempotencySlot struct {
+	uid         int
+	key         string
+	fingerprint string
+}
+
+// idempotent wraps a mutating handler so that requests repeated with the same idempotency key
+// return the original response instead of being handled again.
+func (s *Server) idempotent(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		slot := &idempotencySlot{}
+		recorder := &responseRecorder{ResponseWriter: writer, status: http.StatusOK}
+		handler(recorder, req.WithContext(context.WithValue(req.Context(), idempotencySlotKey{}, slot)))
+		if slot.key == "" {
+			return
+		}
+		err := s.idempotency.Finish(idempotentResponse{
+			UID:         slot.uid,
+			Key:         slot.key,
+			Fingerprint: slot.fingerprint,
+			Status:      recorder.status,
+			Body:        recorder.body.String(),
+			Created:     time.Now(),
+		})
+		if err != nil {
+			log.Printf("failed to store response for idempotency key: %v", err)
+		}
+	}
+}
+
+// checkIdempotency writes the stored response if the request repeats an earlier one with the same
+// idempotency key. It returns whether the request should be handled.
+func (s *Server) checkIdempotency(writer http.ResponseWriter, req *http.Request, uid int, payload []byte) bool {
+	slot, ok := req.Context().Value(idempotencySlotKey{}).(*idempotencySlot)
+	if !ok {
+		return true
+	}
+	var keyed struct {
+		IdempotencyKey string `json:"idempotency_key"`
+	}
+	err := json.Unmarshal(payload, &keyed)
+	if err != nil || keyed.IdempotencyKey == "" {
+		return true
+	}
+	if len(keyed.IdempotencyKey) > maxIdempotencyKeyLength {
+		http.Error(
+			writer,
+			fmt.Sprintf("Idempotency key must not be longer than %d characters.", maxIdempotencyKeyLength),
+			http.StatusBadRequest,
+		)
+		return false
+	}
+	hash := sha256.Sum256(append([]byte(req.URL.Path+"\n"), payload...))
+	fingerprint := hex.EncodeToString(hash[:])
+	response, err := s.idempotency.Begin(uid, keyed.IdempotencyKey, fingerprint, time.Now())
+	switch {
+	case errors.Is(err, errIdempotencyKeyReused):
+		http.Error(writer, "The idempotency key was already used for a different request.", http.StatusBadRequest)
+		return false
+	case errors.Is(err, errIdempotencyKeyInProgress):
+		http.Error(
+			writer,
+			"The request with this idempotency key is still being handled. Try again in a moment.",
+			http.StatusConflict,
+		)
+		return false
+	case response != nil:
+		writer.Header().Set("Idempotent-Replayed", "true")
+		writer.WriteHeader(response.Status)
+		_, _ = writer.Write([]byte(response.Body))
+		return false
+	}
+	slot.uid = uid
+	slot.key = keyed.IdempotencyKey
+	slot.fingerprint = fingerprint
+	return true
+}
+
+// responseRecorder is a http.ResponseWriter that keeps a copy of the response written.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,157 @@
+package storaged
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// folderIndex is an in-memory snapshot of every folder in every tier, built by the folder scanner
+// so that quota lookups do not have to stat every folder.
+type folderIndex struct {
+	mutex sync.RWMutex
+	tiers map[string]*tierIndex
+}
+
+type tierIndex struct {
+	folders map[string]Quota
+	// invalidated records when each folder was last refreshed outside of a scan so that a scan
+	// started before the refresh does not overwrite it with stale data.
+	invalidated map[string]time.Time
+}
+
+func newFolderIndex() *folderIndex {
+	return &folderIndex{tiers: make(map[string]*tierIndex)}
+}
+
+// Replace stores the result of a scan of the tier that started at scanStart.
+func (idx *folderIndex) Replace(tier string, entries []Quota, scanStart time.Time) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	updated := &tierIndex{
+		folders:     make(map[string]Quota, len(entries)),
+		invalidated: make(map[string]time.Time),
+	}
+	for _, entry := range entries {
+		updated.folders[entry.Name] = entry
+	}
+	if previous, ok := idx.tiers[tier]; ok && previous != nil {
+		for name, invalidatedAt := range previous.invalidated {
+			if invalidatedAt.Before(scanStart) {
+				continue
+			}
+			// The folder changed while we were scanning, keep what the refresh found.
+			updated.invalidated[name] = invalidatedAt
+			if entry, ok := previous.folders[name]; ok {
+				updated.folders[name] = entry
+			} else {
+				delete(updated.folders, name)
+			}
+		}
+	}
+	idx.tiers[tier] = updated
+}
+
+// Matching returns the folders in the tier matching the filter along with the sum of their
+// quota. It returns false if the tier has not been scanned yet.
+func (idx *folderIndex) Matching(tier string, match func(owner, pool string) bool) ([]Quota, int, bool) {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	tierIdx := idx.tiers[tier]
+	if tierIdx == nil {
+		return nil, 0, false
+	}
+	quotaEntries := []Quota{}
+	quotaUsed := 0
+	for _, name := range slices.Sorted(maps.Keys(tierIdx.folders)) {
+		entry := tierIdx.folders[name]
+		if !match(entry.Owner, entry.Pool) {
+			continue
+		}
+		quotaUsed += entry.Quota
+		quotaEntries = append(quotaEntries, entry)
+	}
+	return quotaEntries, quotaUsed, true
+}
+
+// Refresh re-reads a single folder after it has been modified.
+func (idx *folderIndex) Refresh(tier string, quotaFS QuotaFS, name string) {
+	entry, err := readFolder(quotaFS, name)
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	tierIdx := idx.tiers[tier]
+	if tierIdx == nil {
+		return
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		delete(tierIdx.folders, name)
+	case err != nil:
+		// We no longer know what the folder looks like. Fall back to reading the tier directly
+		// until the next scan.
+		log.Printf("failed to refresh %s in %s, invalidating index: %v", name, tier, err)
+		idx.tiers[tier] = nil
+		return
+	default:
+		tierIdx.folders[name] = entry
+	}
+	tierIdx.invalidated[name] = time.Now()
+}
+
+// readFolder returns the quota information of a single folder.
+func readFolder(quotaFS QuotaFS, name string) (Quota, error) {
+	entry, err := readMatchingFolder(quotaFS, name, func(_, _ string) bool { return true })
+	if err != nil {
+		return Quota{}, err
+	}
+	return *entry, nil
+}
+
+// folders returns the folders in the tier matching the filter, served from the index if the tier
+// has been scanned and read from the tier otherwise.
+func (s *Server) folders(
+	ctx context.Context, tierName string, match func(owner, pool string) bool,
+) ([]Quota, int, error) {
+	entries, quotaUsed, ok := s.index.Matching(tierName, match)
+	if ok {
+		return entries, quotaUsed, nil
+	}
+	quotaFS, ok := s.Tiers[tierName]
+	if !ok {
+		return nil, 0, fmt.Errorf("tier %q does not exist", tierName)
+	}
+	return quotaMatching(ctx, quotaFS, s.scanWorkers(), match)
+}
+
+// quotaUsed is QuotaUsed served from the index where possible.
+func (s *Server) quotaUsed(ctx context.Context, tierName, userName string) ([]Quota, int, error) {
+	return s.folders(ctx, tierName, func(owner, pool string) bool {
+		return owner == userName && pool == ""
+	})
+}
+
+// poolUsed is PoolUsed served from the index where possible.
+func (s *Server) poolUsed(ctx context.Context, tierName, pool string) ([]Quota, int, error) {
+	return s.folders(ctx, tierName, func(_, folderPool string) bool {
+		return folderPool == pool
+	})
+}
+
+// scanWorkers returns the number of folders to read concurrently.
+func (s *Server) scanWorkers() int {
+	return cmp.Or(s.ScanWorkers, DefaultScanWorkers)
+}
+
+// refreshFolder updates the index after the folder has been modified.
+func (s *Server) refreshFolder(tierName, name string) {
+	if quotaFS, ok := s.Tiers[tierName]; ok {
+		s.index.Refresh(tierName, quotaFS, name)
+	}
+}
@@ -11,14 +11,14 @@ import (
 
 type IPAClientConfig struct {
 	// GroupPrefix is the prefix of the group that needs to match before any request is allowed to proceed.
-	GroupPrefix string
+	GroupPrefix string `toml:"group_prefix"`
 
 	// Host is the address of the FreeIPA host.
-	Host string
+	Host string `toml:"host"`
 	// Username is the name of the user with permission to do the necessary group modifications.
-	Username string
+	Username string `toml:"username"`
 	// Password is the password of the user with permission to do the necessary group modifications.
-	Password string
+	Password string `toml:"password"`
 }
 
 type IPAClient struct {
@@ -67,6 +67,24 @@ func (cli *IPAClient) GroupRemove(groupName string) error {
 	return nil
 }
 
+func (cli *IPAClient) GroupRename(groupName string, newGroupName string) error {
+	err := validateGroupExist(groupName, cli.GroupPrefix)
+	if err != nil {
+		return fmt.Errorf("error validating group name: %w", err)
+	}
+	err = validateGroupAbsent(newGroupName, cli.GroupPrefix)
+	if err != nil {
+		return fmt.Errorf("error validating new group name: %w", err)
+	}
+	_, err = cli.cli.GroupMod(&freeipa.GroupModArgs{Cn: groupName}, &freeipa.GroupModOptionalArgs{
+		Rename: &newGroupName,
+	})
+	if err != nil {
+		return fmt.Errorf("error renaming group: %w", err)
+	}
+	return nil
+}
+
 func (cli *IPAClient) GroupMembers(groupName string, limit int) ([]string, error) {
 	err := validateGroupExist(groupName, cli.GroupPrefix)
 	if err != nil {
@@ -87,6 +105,24 @@ func (cli *IPAClient) GroupMembers(groupName string, limit int) ([]string, error
 	return result, nil
 }
 
+// DisabledUsers returns the names of all disabled accounts.
+func (cli *IPAClient) DisabledUsers() ([]string, error) {
+	locked := true
+	noLimit := 0
+	userFind, err := cli.cli.UserFind("", &freeipa.UserFindArgs{}, &freeipa.UserFindOptionalArgs{
+		Nsaccountlock: &locked,
+		Sizelimit:     &noLimit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing disabled users: %w", err)
+	}
+	result := make([]string, len(userFind.Result))
+	for i := range userFind.Result {
+		result[i] = userFind.Result[i].UID
+	}
+	return result, nil
+}
+
 func (cli *IPAClient) GroupMemberAdd(groupName string, member string) error {
 	err := validateGroupExist(groupName, cli.GroupPrefix)
 	if err != nil {
@@ -133,6 +169,20 @@ func (cli *IPAClient) GroupMemberRemove(groupName string, member string) error {
 	return nil
 }
 
+// Email returns the primary email address of the user, making IPAClient an EmailResolver.
+func (cli *IPAClient) Email(userLogin string) (string, error) {
+	userShow, err := cli.cli.UserShow(&freeipa.UserShowArgs{}, &freeipa.UserShowOptionalArgs{
+		UID: &userLogin,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error looking up user %q: %w", userLogin, err)
+	}
+	if userShow.Result.Mail == nil || len(*userShow.Result.Mail) == 0 {
+		return "", fmt.Errorf("user %q has no email address", userLogin)
+	}
+	return (*userShow.Result.Mail)[0], nil
+}
+
 func validateUserExist(userLogin string) error {
 	_, err := user.Lookup(userLogin)
 	if err != nil {
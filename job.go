@@ -0,0 +1,289 @@
+package storaged
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+const jobStateFile = "jobs.json"
+
+// finishedJobRetention is how long finished jobs are remembered for.
+const finishedJobRetention = 24 * time.Hour
+
+// DefaultMaxJobsPerUser is the number of jobs a user may have running at once by default.
+const DefaultMaxJobsPerUser = 2
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+const (
+	// JobKindMigrate moves a folder to another tier.
+	JobKindMigrate = "migrate"
+	// JobKindDelete permanently removes a folder.
+	JobKindDelete = "delete"
+)
+
+// ErrJobFinished is returned when cancelling a job that has already finished.
+var ErrJobFinished = errors.New("job has already finished")
+
+// Job is a long-running operation performed in the background.
+type Job struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	Owner string `json:"owner"`
+	// Tier and Folder identify the folder the job operates on.
+	Tier   string `json:"tier"`
+	Folder string `json:"folder"`
+	// DestinationTier is the tier a folder is migrated to.
+	DestinationTier string `json:"destination_tier,omitempty"`
+	// Snapshot and Path identify what is restored by a snapshot restore.
+	Snapshot string `json:"snapshot,omitempty"`
+	Path     string `json:"path,omitempty"`
+	// NewOwner and PreviousUID identify whose files are given to whom when a folder is reassigned.
+	NewOwner    string `json:"new_owner,omitempty"`
+	PreviousUID int    `json:"previous_uid,omitempty"`
+	// Description is a human-readable summary of what the job does.
+	Description string   `json:"description"`
+	State       JobState `json:"state"`
+	// Done and Total measure the progress of the job in bytes.
+	Done     int       `json:"done"`
+	Total    int       `json:"total"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Finished time.Time `json:"finished,omitzero"`
+}
+
+// Active reports whether the job is queued or running.
+func (j Job) Active() bool {
+	return j.State == JobQueued || j.State == JobRunning
+}
+
+// Describe returns a human-readable summary of the job and its progress.
+func (j Job) Describe() string {
+	switch j.State {
+	case JobRunning:
+		if j.Total <= 0 && j.Done > 0 {
+			return fmt.Sprintf("%s: %s copied so far", j.Description, FormatByteSize(j.Done))
+		}
+		if j.Total <= 0 {
+			return fmt.Sprintf("%s: in progress", j.Description)
+		}
+		return fmt.Sprintf(
+			"%s: %d%% (%s / %s)",
+			j.Description, int(float64(min(j.Done, j.Total))*100/float64(j.Total)),
+			FormatByteSize(j.Done), FormatByteSize(j.Total),
+		)
+	case JobQueued:
+		return fmt.Sprintf("%s: waiting for your other operations to finish", j.Description)
+	case JobFailed:
+		return fmt.Sprintf("%s: failed: %s", j.Description, j.Error)
+	default:
+		return fmt.Sprintf("%s: %s", j.Description, j.State)
+	}
+}
+
+// jobRunner performs a job, reporting its progress through the provided function. It must stop
+// and undo what it can once ctx is cancelled, and must be safe to run again on a job that was
+// interrupted by a restart.
+type jobRunner func(ctx context.Context, job Job, progress func(done, total int)) error
+
+// jobTracker queues jobs, runs them in the background with a per-user concurrency limit and
+// persists their records so that interrupted jobs are resumed after a restart.
+type jobTracker struct {
+	mutex      sync.Mutex
+	path       string
+	maxPerUser int
+	run        jobRunner
+	jobs       map[string]*Job
+	cancels    map[string]context.CancelFunc
+}
+
+func loadJobTracker(statePath string, maxPerUser int, run jobRunner) (*jobTracker, error) {
+	t := &jobTracker{
+		path:       statePath,
+		maxPerUser: cmp.Or(maxPerUser, DefaultMaxJobsPerUser),
+		run:        run,
+		jobs:       make(map[string]*Job),
+		cancels:    make(map[string]context.CancelFunc),
+	}
+	err := loadState(statePath, &t.jobs)
+	if err != nil {
+		return nil, fmt.Errorf("error loading jobs: %w", err)
+	}
+	return t, nil
+}
+
+// Resume starts the jobs that were queued or running when the tracker was last saved.
+func (t *jobTracker) Resume() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, job := range t.jobs {
+		if job.State == JobRunning {
+			log.Printf("resuming interrupted job %s (%s)", job.ID, job.Description)
+			job.State = JobQueued
+		}
+	}
+	t.dispatchLocked()
+}
+
+// Submit queues the job and returns it with its ID assigned.
+func (t *jobTracker) Submit(job Job) (Job, error) {
+	id, err := randomID()
+	if err != nil {
+		return Job{}, err
+	}
+	job.ID = id
+	job.State = JobQueued
+	job.Created = time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.pruneLocked()
+	t.jobs[job.ID] = &job
+	t.dispatchLocked()
+	return *t.jobs[job.ID], nil
+}
+
+// Cancel stops the job. Queued jobs are still run with a cancelled context so that they can undo
+// their preparations.
+func (t *jobTracker) Cancel(id string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	job, ok := t.jobs[id]
+	switch {
+	case !ok:
+		return fmt.Errorf("job %s does not exist", id)
+	case job.State == JobRunning:
+		t.cancels[id]()
+	case job.State == JobQueued:
+		t.startLocked(job, true)
+	default:
+		return ErrJobFinished
+	}
+	return nil
+}
+
+// dispatchLocked starts queued jobs, oldest first, as long as their owner is below the
+// concurrency limit. It saves the job records.
+func (t *jobTracker) dispatchLocked() {
+	running := make(map[string]int)
+	for _, job := range t.jobs {
+		if job.State == JobRunning {
+			running[job.Owner]++
+		}
+	}
+	queued := slices.SortedFunc(maps.Values(t.jobs), func(a, b *Job) int {
+		return a.Created.Compare(b.Created)
+	})
+	for _, job := range queued {
+		if job.State != JobQueued || running[job.Owner] >= t.maxPerUser {
+			continue
+		}
+		running[job.Owner]++
+		t.startLocked(job, false)
+	}
+	t.saveLocked()
+}
+
+func (t *jobTracker) startLocked(job *Job, cancelled bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if cancelled {
+		cancel()
+	}
+	id := job.ID
+	job.State = JobRunning
+	t.cancels[id] = cancel
+	snapshot := *job
+	go func() {
+		err := t.run(ctx, snapshot, func(done, total int) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			t.jobs[id].Done = done
+			t.jobs[id].Total = total
+		})
+		cancel()
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		delete(t.cancels, id)
+		finished := t.jobs[id]
+		finished.Finished = time.Now()
+		switch {
+		case errors.Is(err, context.Canceled):
+			finished.State = JobCancelled
+		case err != nil:
+			log.Printf("job %s (%s) failed: %v", id, finished.Description, err)
+			finished.State = JobFailed
+			finished.Error = err.Error()
+		default:
+			finished.State = JobSucceeded
+		}
+		t.dispatchLocked()
+	}()
+}
+
+func (t *jobTracker) saveLocked() {
+	err := saveState(t.path, t.jobs)
+	if err != nil {
+		log.Printf("failed to save jobs: %v", err)
+	}
+}
+
+// Get returns the job with the specified ID.
+func (t *jobTracker) Get(id string) (Job, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	job, ok := t.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Active reports whether a job is queued or running on the folder.
+func (t *jobTracker) Active(tier, folder string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, job := range t.jobs {
+		if job.Active() && job.Tier == tier && job.Folder == folder {
+			return true
+		}
+	}
+	return false
+}
+
+// Owned returns the jobs of the user that are active or finished recently, oldest first.
+func (t *jobTracker) Owned(userName string) []Job {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var result []Job
+	for _, job := range t.jobs {
+		if job.Owner == userName {
+			result = append(result, *job)
+		}
+	}
+	slices.SortFunc(result, func(a, b Job) int {
+		return a.Created.Compare(b.Created)
+	})
+	return result
+}
+
+func (t *jobTracker) pruneLocked() {
+	for id, job := range t.jobs {
+		if !job.Active() && time.Since(job.Finished) > finishedJobRetention {
+			delete(t.jobs, id)
+		}
+	}
+}
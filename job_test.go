@@ -0,0 +1,219 @@
+package storaged
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// blockingRunner is a jobRunner that reports every job it starts and then waits until the folder
+// of the job is released with the job's result or the job is cancelled.
+type blockingRunner struct {
+	started chan Job
+	release map[string]chan error
+}
+
+func newBlockingRunner(folders ...string) *blockingRunner {
+	r := &blockingRunner{started: make(chan Job, 16), release: make(map[string]chan error)}
+	for _, folder := range folders {
+		r.release[folder] = make(chan error, 1)
+	}
+	return r
+}
+
+func (r *blockingRunner) run(ctx context.Context, job Job, _ func(done, total int)) error {
+	r.started <- job
+	select {
+	case err := <-r.release[job.Folder]:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// expectStarted waits for the jobs on the folders to be started in any order.
+func (r *blockingRunner) expectStarted(t *testing.T, folders ...string) {
+	t.Helper()
+	want := make(map[string]bool)
+	for _, folder := range folders {
+		want[folder] = true
+	}
+	for range folders {
+		select {
+		case job := <-r.started:
+			if !want[job.Folder] {
+				t.Fatalf("job on %s started, want %v", job.Folder, folders)
+			}
+			delete(want, job.Folder)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("jobs on %v were not started", folders)
+		}
+	}
+}
+
+// expectIdle checks that no further job is started.
+func (r *blockingRunner) expectIdle(t *testing.T) {
+	t.Helper()
+	select {
+	case job := <-r.started:
+		t.Fatalf("job on %s started unexpectedly", job.Folder)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// waitForState waits for the job to reach the state.
+func waitForState(t *testing.T, tracker *jobTracker, id string, state JobState) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, ok := tracker.Get(id)
+		if !ok {
+			t.Fatalf("job %s does not exist", id)
+		}
+		if job.State == state {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, job.State, state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func submitJob(t *testing.T, tracker *jobTracker, owner, folder string) Job {
+	t.Helper()
+	job, err := tracker.Submit(Job{Kind: JobKindMigrate, Owner: owner, Tier: "hdd", Folder: folder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestJobTrackerPerUserLimit(t *testing.T) {
+	runner := newBlockingRunner("a1", "a2", "b1")
+	tracker, err := loadJobTracker(filepath.Join(t.TempDir(), jobStateFile), 1, runner.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1 := submitJob(t, tracker, "alice", "a1")
+	a2 := submitJob(t, tracker, "alice", "a2")
+	b1 := submitJob(t, tracker, "bob", "b1")
+	runner.expectStarted(t, "a1", "b1")
+	runner.expectIdle(t)
+	if job, _ := tracker.Get(a2.ID); job.State != JobQueued {
+		t.Errorf("a2 is %s while a1 is running, want %s", job.State, JobQueued)
+	}
+	if !tracker.Active("hdd", "a2") {
+		t.Error("queued job is not active")
+	}
+
+	runner.release["a1"] <- errors.New("disk on fire")
+	failed := waitForState(t, tracker, a1.ID, JobFailed)
+	if failed.Error != "disk on fire" || failed.Finished.IsZero() {
+		t.Errorf("a1 finished with error %q at %s", failed.Error, failed.Finished)
+	}
+	runner.expectStarted(t, "a2")
+	runner.release["a2"] <- nil
+	runner.release["b1"] <- nil
+	waitForState(t, tracker, a2.ID, JobSucceeded)
+	waitForState(t, tracker, b1.ID, JobSucceeded)
+	if tracker.Active("hdd", "a2") {
+		t.Error("finished job is still active")
+	}
+	owned := tracker.Owned("alice")
+	if len(owned) != 2 || owned[0].ID != a1.ID || owned[1].ID != a2.ID {
+		t.Errorf("Owned(alice) = %v, want a1 and a2 oldest first", owned)
+	}
+}
+
+func TestJobTrackerCancel(t *testing.T) {
+	runner := newBlockingRunner("running", "queued")
+	tracker, err := loadJobTracker(filepath.Join(t.TempDir(), jobStateFile), 1, runner.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running := submitJob(t, tracker, "alice", "running")
+	queued := submitJob(t, tracker, "alice", "queued")
+	runner.expectStarted(t, "running")
+
+	// Cancelled queued jobs are still run so that they can undo their preparations, even though
+	// their owner is at the limit.
+	err = tracker.Cancel(queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.expectStarted(t, "queued")
+	waitForState(t, tracker, queued.ID, JobCancelled)
+	if job, _ := tracker.Get(running.ID); job.State != JobRunning {
+		t.Errorf("running job is %s after cancelling another job", job.State)
+	}
+
+	err = tracker.Cancel(running.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, tracker, running.ID, JobCancelled)
+
+	err = tracker.Cancel(running.ID)
+	if !errors.Is(err, ErrJobFinished) {
+		t.Errorf("cancelling a finished job returned %v, want %v", err, ErrJobFinished)
+	}
+	err = tracker.Cancel("missing")
+	if err == nil {
+		t.Error("cancelling a missing job succeeded")
+	}
+}
+
+func TestJobTrackerResume(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), jobStateFile)
+	now := time.Now()
+	jobs := map[string]*Job{
+		"interrupted": {
+			ID: "interrupted", Owner: "alice", Folder: "interrupted", State: JobRunning, Created: now.Add(-3 * time.Minute),
+		},
+		"queued": {ID: "queued", Owner: "alice", Folder: "queued", State: JobQueued, Created: now.Add(-2 * time.Minute)},
+		"later":  {ID: "later", Owner: "alice", Folder: "later", State: JobQueued, Created: now.Add(-time.Minute)},
+		"done": {
+			ID: "done", Owner: "alice", Folder: "done", State: JobSucceeded, Created: now.Add(-time.Hour), Finished: now,
+		},
+	}
+	err := saveState(statePath, jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := newBlockingRunner("interrupted", "queued", "later", "done")
+	tracker, err := loadJobTracker(statePath, 2, runner.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.expectIdle(t)
+
+	tracker.Resume()
+	// The interrupted job is run again ahead of the jobs queued after it.
+	runner.expectStarted(t, "interrupted", "queued")
+	runner.expectIdle(t)
+	if job, _ := tracker.Get("later"); job.State != JobQueued {
+		t.Errorf("later job is %s, want %s", job.State, JobQueued)
+	}
+	if job, _ := tracker.Get("done"); job.State != JobSucceeded {
+		t.Errorf("finished job is %s after resuming, want %s", job.State, JobSucceeded)
+	}
+
+	// The records are saved so that a second restart resumes the same jobs.
+	var saved map[string]*Job
+	err = loadState(statePath, &saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved["interrupted"].State != JobRunning || saved["later"].State != JobQueued {
+		t.Errorf("saved states are %s and %s", saved["interrupted"].State, saved["later"].State)
+	}
+
+	runner.release["interrupted"] <- nil
+	runner.expectStarted(t, "later")
+	runner.release["queued"] <- nil
+	runner.release["later"] <- nil
+	waitForState(t, tracker, "later", JobSucceeded)
+}
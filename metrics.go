@@ -0,0 +1,224 @@
+package storaged
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the request latency histogram in seconds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metrics collects the metrics exposed on /metrics in the Prometheus text format.
+type metrics struct {
+	mutex sync.Mutex
+
+	requests        map[requestKey]*histogram
+	rateLimited     int
+	unmungeFailures int
+
+	// The following are gathered by the folder scanner rather than on every scrape.
+	lastScan      time.Time
+	tierAllocated map[string]int
+	tierUsed      map[string]int
+	tierOrphaned  map[string]int
+	userUsed      map[string]map[string]int
+}
+
+type requestKey struct {
+	handler string
+	code    int
+}
+
+type histogram struct {
+	buckets []int
+	sum     float64
+	count   int
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:      make(map[requestKey]*histogram),
+		tierAllocated: make(map[string]int),
+		tierUsed:      make(map[string]int),
+		tierOrphaned:  make(map[string]int),
+		userUsed:      make(map[string]map[string]int),
+	}
+}
+
+func (m *metrics) observeRequest(handler string, code int, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := requestKey{handler, code}
+	h, ok := m.requests[key]
+	if !ok {
+		h = &histogram{buckets: make([]int, len(latencyBuckets))}
+		m.requests[key] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *metrics) incRateLimited() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rateLimited++
+}
+
+func (m *metrics) incUnmungeFailures() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.unmungeFailures++
+}
+
+// updateUsage replaces the usage gauges of the tier with the scanned entries.
+func (m *metrics) updateUsage(tier string, entries []Quota) {
+	allocated, used, orphaned := 0, 0, 0
+	userUsed := make(map[string]int)
+	for _, entry := range entries {
+		allocated += entry.Quota
+		used += entry.Usage
+		if entry.Owner == "" {
+			orphaned += entry.Usage
+			continue
+		}
+		userUsed[entry.Owner] += entry.Usage
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lastScan = time.Now()
+	m.tierAllocated[tier] = allocated
+	m.tierUsed[tier] = used
+	m.tierOrphaned[tier] = orphaned
+	m.userUsed[tier] = userUsed
+}
+
+// writeTo writes all metrics in the Prometheus text exposition format. The usage of individual
+// users is only included if userUsage is set.
+func (m *metrics) writeTo(w io.Writer, userUsage bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	writeHeader(w, "storaged_http_requests_total", "counter", "Number of HTTP requests handled.")
+	keys := slices.SortedFunc(maps.Keys(m.requests), func(a, b requestKey) int {
+		return cmp.Or(strings.Compare(a.handler, b.handler), a.code-b.code)
+	})
+	for _, key := range keys {
+		_, _ = fmt.Fprintf(
+			w, "storaged_http_requests_total{handler=%s,code=\"%d\"} %d\n",
+			quoteLabel(key.handler), key.code, m.requests[key].count,
+		)
+	}
+	writeHeader(w, "storaged_http_request_duration_seconds", "histogram", "Latency of HTTP requests.")
+	for _, key := range keys {
+		h := m.requests[key]
+		labels := fmt.Sprintf("handler=%s,code=\"%d\"", quoteLabel(key.handler), key.code)
+		for i, bound := range latencyBuckets {
+			_, _ = fmt.Fprintf(
+				w, "storaged_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels, strconv.FormatFloat(bound, 'g', -1, 64), h.buckets[i],
+			)
+		}
+		_, _ = fmt.Fprintf(w, "storaged_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		_, _ = fmt.Fprintf(w, "storaged_http_request_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		_, _ = fmt.Fprintf(w, "storaged_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	writeHeader(w, "storaged_rate_limited_requests_total", "counter", "Number of requests rejected by the rate limiter.")
+	_, _ = fmt.Fprintf(w, "storaged_rate_limited_requests_total %d\n", m.rateLimited)
+	writeHeader(w, "storaged_unmunge_failures_total", "counter", "Number of requests whose credential failed to unmunge.")
+	_, _ = fmt.Fprintf(w, "storaged_unmunge_failures_total %d\n", m.unmungeFailures)
+
+	if m.lastScan.IsZero() {
+		return
+	}
+	writeHeader(w, "storaged_last_scan_timestamp_seconds", "gauge", "Time the usage gauges were last updated.")
+	_, _ = fmt.Fprintf(w, "storaged_last_scan_timestamp_seconds %d\n", m.lastScan.Unix())
+	writeHeader(w, "storaged_tier_allocated_bytes", "gauge", "Sum of the quota of all folders in the tier.")
+	for _, tier := range slices.Sorted(maps.Keys(m.tierAllocated)) {
+		_, _ = fmt.Fprintf(w, "storaged_tier_allocated_bytes{tier=%s} %d\n", quoteLabel(tier), m.tierAllocated[tier])
+	}
+	writeHeader(w, "storaged_tier_used_bytes", "gauge", "Sum of the usage of all folders in the tier.")
+	for _, tier := range slices.Sorted(maps.Keys(m.tierUsed)) {
+		_, _ = fmt.Fprintf(w, "storaged_tier_used_bytes{tier=%s} %d\n", quoteLabel(tier), m.tierUsed[tier])
+	}
+	writeHeader(w, "storaged_tier_orphaned_bytes", "gauge", "Sum of the usage of all folders in the tier whose owner no longer exists.")
+	for _, tier := range slices.Sorted(maps.Keys(m.tierOrphaned)) {
+		_, _ = fmt.Fprintf(w, "storaged_tier_orphaned_bytes{tier=%s} %d\n", quoteLabel(tier), m.tierOrphaned[tier])
+	}
+	if !userUsage {
+		return
+	}
+	writeHeader(w, "storaged_user_used_bytes", "gauge", "Sum of the usage of all folders owned by the user in the tier.")
+	for _, tier := range slices.Sorted(maps.Keys(m.userUsed)) {
+		for _, userName := range slices.Sorted(maps.Keys(m.userUsed[tier])) {
+			_, _ = fmt.Fprintf(
+				w, "storaged_user_used_bytes{tier=%s,user=%s} %d\n",
+				quoteLabel(tier), quoteLabel(userName), m.userUsed[tier][userName],
+			)
+		}
+	}
+}
+
+func writeHeader(w io.Writer, name, metricType, help string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// quoteLabel quotes a label value as required by the Prometheus text format.
+func quoteLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + value + `"`
+}
+
+func (s *Server) handleMetrics(writer http.ResponseWriter, req *http.Request) {
+	if s.MetricsAllowedHost != nil {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil || !s.MetricsAllowedHost.Contains(net.ParseIP(host)) {
+			http.Error(writer, "Metrics are not available to this host.", http.StatusForbidden)
+			return
+		}
+	}
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.writeTo(writer, s.MetricsUserUsage)
+}
+
+// instrument wraps the handler to record its request count and latency.
+func (s *Server) instrument(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+		handler(recorder, req)
+		s.metrics.observeRequest(name, recorder.status, time.Since(start))
+	}
+}
+
+// statusRecorder is a http.ResponseWriter that remembers the status code written.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,108 @@
+package storaged
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+type EventKind string
+
+const (
+	// EventAllocationChanged is sent when an administrator changes the allocation of a user.
+	EventAllocationChanged EventKind = "allocation_changed"
+	// EventGrantExpiring is sent ahead of a temporary grant expiring.
+	EventGrantExpiring EventKind = "grant_expiring"
+	// EventGrantExpired is sent when a temporary grant has expired.
+	EventGrantExpired EventKind = "grant_expired"
+	// EventOverAllocated is sent when a user is found to be assigned more than their allocation.
+	EventOverAllocated EventKind = "over_allocated"
+	// EventGraceExpired is sent when the GracePolicy has been enforced on a user.
+	EventGraceExpired EventKind = "grace_expired"
+	// EventThresholdCrossed is sent when the usage of a folder crosses a soft threshold.
+	EventThresholdCrossed EventKind = "threshold_crossed"
+	// EventFolderCreated is sent when a user creates a folder.
+	EventFolderCreated EventKind = "folder_created"
+	// EventFolderResized is sent when a user changes the quota of a folder.
+	EventFolderResized EventKind = "folder_resized"
+	// EventFolderMigrated is sent when a folder has been moved to another tier.
+	EventFolderMigrated EventKind = "folder_migrated"
+	// EventFolderDeleted is sent when a user deletes a folder.
+	EventFolderDeleted EventKind = "folder_deleted"
+	// EventFolderReassigned is sent when an administrator gives a user the folder of someone who
+	// has left.
+	EventFolderReassigned EventKind = "folder_reassigned"
+	// EventFolderExpiring is sent ahead of a folder expiring.
+	EventFolderExpiring EventKind = "folder_expiring"
+	// EventFolderExpired is sent when an expired folder has been moved to the trash.
+	EventFolderExpired EventKind = "folder_expired"
+	// EventRequestApproved is sent when a request waiting for approval has been approved.
+	EventRequestApproved EventKind = "request_approved"
+	// EventRequestDenied is sent when a request waiting for approval has been denied or could no
+	// longer be applied once approved.
+	EventRequestDenied EventKind = "request_denied"
+)
+
+// Event is something that happened to a user's storage that they should be notified about.
+type Event struct {
+	Kind EventKind `json:"kind"`
+	Time time.Time `json:"time"`
+	// User is the user the event concerns.
+	User   string `json:"user"`
+	Tier   string `json:"tier,omitempty"`
+	Folder string `json:"folder,omitempty"`
+	Bytes  int    `json:"bytes,omitempty"`
+	// Deadline is when the thing the event warns about happens, if applicable.
+	Deadline time.Time `json:"deadline,omitzero"`
+	// Message is a human-readable description of the event.
+	Message string `json:"message"`
+}
+
+// Notifier delivers events to the users they concern.
+type Notifier interface {
+	Notify(event Event) error
+}
+
+// LogNotifier is a Notifier that only writes events to the log.
+type LogNotifier struct{}
+
+var _ Notifier = LogNotifier{}
+
+func (LogNotifier) Notify(event Event) error {
+	log.Printf("event %s for %s: %s", event.Kind, event.User, event.Message)
+	return nil
+}
+
+// MultiNotifier is a Notifier that sends every event to all of its Notifiers.
+type MultiNotifier []Notifier
+
+var _ Notifier = MultiNotifier(nil)
+
+func (m MultiNotifier) Notify(event Event) error {
+	var errs []error
+	for _, notifier := range m {
+		err := notifier.Notify(event)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// notify sends the event through the configured Notifier in the background, logging any failure
+// as there is usually nobody to report the failure to.
+func (s *Server) notify(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	notifier := s.Notifier
+	if notifier == nil {
+		notifier = LogNotifier{}
+	}
+	go func() {
+		err := notifier.Notify(event)
+		if err != nil {
+			log.Printf("failed to deliver %s event to %s: %v", event.Kind, event.User, err)
+		}
+	}()
+}
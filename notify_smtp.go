@@ -0,0 +1,153 @@
+package storaged
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultEmailSubjects are the subject templates used for each kind of event unless overridden.
+var DefaultEmailSubjects = map[EventKind]string{
+	EventAllocationChanged: "Your storage allocation has changed",
+	EventGrantExpiring:     "Your storage grant on {{.Tier}} is about to expire",
+	EventGrantExpired:      "Your storage grant on {{.Tier}} has expired",
+	EventOverAllocated:     "You are over your storage allocation on {{.Tier}}",
+	EventGraceExpired:      "Your folders on {{.Tier}} have been reduced",
+	EventThresholdCrossed:  "Your folder {{.Folder}} is almost full",
+	EventFolderCreated:     "Your folder {{.Folder}} has been created",
+	EventFolderResized:     "The quota of your folder {{.Folder}} has changed",
+	EventFolderMigrated:    "Your folder {{.Folder}} has been migrated to {{.Tier}}",
+	EventFolderDeleted:     "Your folder {{.Folder}} has been deleted",
+	EventFolderReassigned:  "You have been given the folder {{.Folder}} on {{.Tier}}",
+	EventFolderExpiring:    "Your folder {{.Folder}} on {{.Tier}} is about to expire",
+	EventFolderExpired:     "Your folder {{.Folder}} on {{.Tier}} has expired",
+	EventRequestApproved:   "Your request for {{.Folder}} has been approved",
+	EventRequestDenied:     "Your request for {{.Folder}} has been denied",
+}
+
+// DefaultEmailBody is the body template used for every event unless overridden.
+const DefaultEmailBody = `Hello {{.User}},
+
+{{.Message}}
+{{- if not .Deadline.IsZero}}
+
+This is due on {{.Deadline.Format "2006-01-02 15:04"}}.
+{{- end}}
+
+Run storagemgr on any login node to manage your storage.
+
+-- 
+This message was sent automatically by storaged.
+`
+
+// EmailResolver finds the email address of a user.
+type EmailResolver interface {
+	Email(userName string) (string, error)
+}
+
+// EmailDomain is an EmailResolver that assumes every user has an address of the form
+// user@domain.
+type EmailDomain string
+
+func (d EmailDomain) Email(userName string) (string, error) {
+	return userName + "@" + string(d), nil
+}
+
+type SMTPConfig struct {
+	// Addr is the address of the SMTP server in the form host:port.
+	Addr string `toml:"addr"`
+	// Username and Password are used for authentication if Username is not empty.
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	// From is the address emails are sent from.
+	From string `toml:"from"`
+	// Subjects overrides the subject template used for each kind of event.
+	Subjects map[EventKind]string `toml:"subjects"`
+	// Body overrides the body template used for every event.
+	Body string `toml:"body"`
+}
+
+// SMTPNotifier is a Notifier that emails the user the event concerns.
+type SMTPNotifier struct {
+	SMTPConfig
+	resolver EmailResolver
+	subjects map[EventKind]*template.Template
+	body     *template.Template
+}
+
+var _ Notifier = (*SMTPNotifier)(nil)
+
+func NewSMTPNotifier(cfg SMTPConfig, resolver EmailResolver) (*SMTPNotifier, error) {
+	notifier := &SMTPNotifier{
+		SMTPConfig: cfg,
+		resolver:   resolver,
+		subjects:   make(map[EventKind]*template.Template),
+	}
+	for kind, subject := range DefaultEmailSubjects {
+		if override, ok := cfg.Subjects[kind]; ok {
+			subject = override
+		}
+		tmpl, err := template.New(string(kind)).Parse(subject)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing subject template for %s: %w", kind, err)
+		}
+		notifier.subjects[kind] = tmpl
+	}
+	body := cfg.Body
+	if body == "" {
+		body = DefaultEmailBody
+	}
+	var err error
+	notifier.body, err = template.New("body").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing body template: %w", err)
+	}
+	return notifier, nil
+}
+
+func (n *SMTPNotifier) Notify(event Event) error {
+	to, err := n.resolver.Email(event.User)
+	if err != nil {
+		return fmt.Errorf("error resolving email address of %s: %w", event.User, err)
+	}
+	var subject bytes.Buffer
+	if tmpl, ok := n.subjects[event.Kind]; ok {
+		err = tmpl.Execute(&subject, event)
+	} else {
+		_, err = fmt.Fprintf(&subject, "Storage notification: %s", event.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("error rendering subject: %w", err)
+	}
+	var body bytes.Buffer
+	err = n.body.Execute(&body, event)
+	if err != nil {
+		return fmt.Errorf("error rendering body: %w", err)
+	}
+	var msg bytes.Buffer
+	_, _ = fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	_, _ = fmt.Fprintf(&msg, "To: %s\r\n", to)
+	_, _ = fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject.String()))
+	_, _ = fmt.Fprintf(&msg, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	_, _ = fmt.Fprint(&msg, "MIME-Version: 1.0\r\n")
+	_, _ = fmt.Fprint(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	_, _ = fmt.Fprint(&msg, strings.ReplaceAll(body.String(), "\n", "\r\n"))
+	var auth smtp.Auth
+	if n.Username != "" {
+		host, _, err := net.SplitHostPort(n.Addr)
+		if err != nil {
+			return fmt.Errorf("error parsing SMTP address: %w", err)
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+	err = smtp.SendMail(n.Addr, auth, n.From, []string{to}, msg.Bytes())
+	if err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package storaged
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receivedEmail is an email accepted by fakeSMTPServer.
+type receivedEmail struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTPServer accepts emails on 127.0.0.1 without authentication and sends each of them to
+// the returned channel. It returns the address it listens on.
+func fakeSMTPServer(t *testing.T) (string, <-chan receivedEmail) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	emails := make(chan receivedEmail, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, emails)
+		}
+	}()
+	return listener.Addr().String(), emails
+}
+
+func serveSMTP(conn net.Conn, emails chan<- receivedEmail) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost ESMTP")
+	var email receivedEmail
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO", "NOOP", "RSET":
+			reply("250 localhost")
+		case "MAIL":
+			email = receivedEmail{from: strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")}
+			reply("250 OK")
+		case "RCPT":
+			email.to = append(email.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			email.data = data.String()
+			emails <- email
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, emails := fakeSMTPServer(t)
+	notifier, err := NewSMTPNotifier(SMTPConfig{Addr: addr, From: "storaged@example.com"}, EmailDomain("example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Date(2024, 3, 1, 12, 30, 0, 0, time.Local)
+	tests := []struct {
+		event       Event
+		wantSubject string
+		wantBody    []string
+	}{
+		{
+			event: Event{
+				Kind:    EventFolderCreated,
+				Time:    time.Now(),
+				User:    "alice",
+				Tier:    "hdd",
+				Folder:  "proj1",
+				Message: "Folder proj1 has been created in hdd.",
+			},
+			wantSubject: "Your folder proj1 has been created",
+			wantBody:    []string{"Hello alice,", "Folder proj1 has been created in hdd."},
+		},
+		{
+			event: Event{
+				Kind:     EventFolderExpiring,
+				Time:     time.Now(),
+				User:     "bob",
+				Tier:     "ssd",
+				Folder:   "scratch",
+				Deadline: deadline,
+				Message:  "Your folder scratch in ssd expires soon.",
+			},
+			wantSubject: "Your folder scratch on ssd is about to expire",
+			wantBody: []string{
+				"Hello bob,", "Your folder scratch in ssd expires soon.", "This is due on 2024-03-01 12:30.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.event.Kind), func(t *testing.T) {
+			err := notifier.Notify(tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var email receivedEmail
+			select {
+			case email = <-emails:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no email received")
+			}
+			wantTo := tt.event.User + "@example.com"
+			if email.from != "storaged@example.com" || len(email.to) != 1 || email.to[0] != wantTo {
+				t.Errorf("envelope from %s to %v, want from storaged@example.com to %s", email.from, email.to, wantTo)
+			}
+			msg, err := mail.ReadMessage(strings.NewReader(email.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := msg.Header.Get("To"); got != wantTo {
+				t.Errorf("To = %q, want %q", got, wantTo)
+			}
+			subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject != tt.wantSubject {
+				t.Errorf("Subject = %q, want %q", subject, tt.wantSubject)
+			}
+			body, err := io.ReadAll(msg.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("body does not contain %q:\n%s", want, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package storaged
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader holds the hex-encoded HMAC-SHA256 of the body keyed with the secret of
+	// the webhook, prefixed with "sha256=".
+	WebhookSignatureHeader = "X-Storaged-Signature"
+	// WebhookEventHeader holds the kind of the event being delivered.
+	WebhookEventHeader = "X-Storaged-Event"
+)
+
+// DefaultWebhookAttempts is how many times delivery to a webhook is attempted by default.
+const DefaultWebhookAttempts = 5
+
+type WebhookConfig struct {
+	// URL is the endpoint events are POSTed to as JSON.
+	URL string `toml:"url"`
+	// Secret is the key used to sign the payload.
+	Secret string `toml:"secret"`
+	// Events is the list of event kinds to deliver. If empty, every event is delivered.
+	Events []EventKind `toml:"events"`
+}
+
+// WebhookNotifier is a Notifier that delivers events to webhooks, retrying with exponential
+// backoff. Events that still cannot be delivered are appended to a dead-letter file.
+type WebhookNotifier struct {
+	webhooks       []WebhookConfig
+	client         *http.Client
+	attempts       int
+	initialBackoff time.Duration
+	deadLetterPath string
+	deadLetterLock sync.Mutex
+}
+
+var _ Notifier = (*WebhookNotifier)(nil)
+
+// NewWebhookNotifier returns a WebhookNotifier delivering to the webhooks. Undeliverable events
+// are written to deadLetterPath as JSON lines, or only logged if it is empty.
+func NewWebhookNotifier(webhooks []WebhookConfig, deadLetterPath string) *WebhookNotifier {
+	return &WebhookNotifier{
+		webhooks:       webhooks,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		attempts:       DefaultWebhookAttempts,
+		initialBackoff: time.Second,
+		deadLetterPath: deadLetterPath,
+	}
+}
+
+func (n *WebhookNotifier) Notify(event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding event: %w", err)
+	}
+	var errs []error
+	for _, webhook := range n.webhooks {
+		if len(webhook.Events) > 0 && !slices.Contains(webhook.Events, event.Kind) {
+			continue
+		}
+		err := n.deliver(webhook, event.Kind, payload)
+		if err != nil {
+			n.deadLetter(webhook, payload, err)
+			errs = append(errs, fmt.Errorf("error delivering to %s: %w", webhook.URL, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deliver posts the payload to the webhook until it succeeds or runs out of attempts.
+func (n *WebhookNotifier) deliver(webhook WebhookConfig, kind EventKind, payload []byte) error {
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write(payload)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	backoff := n.initialBackoff
+	var err error
+	for attempt := range n.attempts {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		err = n.post(webhook.URL, kind, signature, payload)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (n *WebhookNotifier) post(url string, kind EventKind, signature string, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(kind))
+	req.Header.Set(WebhookSignatureHeader, signature)
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// deadLetter records an event that could not be delivered so that it can be replayed manually.
+func (n *WebhookNotifier) deadLetter(webhook WebhookConfig, payload []byte, deliveryErr error) {
+	if n.deadLetterPath == "" {
+		return
+	}
+	entry, err := json.Marshal(struct {
+		URL   string          `json:"url"`
+		Time  time.Time       `json:"time"`
+		Error string          `json:"error"`
+		Event json.RawMessage `json:"event"`
+	}{webhook.URL, time.Now(), deliveryErr.Error(), payload})
+	if err != nil {
+		log.Printf("failed to encode dead letter: %v", err)
+		return
+	}
+	n.deadLetterLock.Lock()
+	defer n.deadLetterLock.Unlock()
+	f, err := os.OpenFile(n.deadLetterPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		log.Printf("failed to open dead letter file: %v", err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	_, err = f.Write(append(entry, '\n'))
+	if err != nil {
+		log.Printf("failed to write dead letter: %v", err)
+	}
+}
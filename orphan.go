@@ -0,0 +1,346 @@
+package storaged
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os/user"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ArchiveDir is the directory in each tier that folders of departed users are archived to.
+	// Archived folders are not charged to anyone and are kept until an administrator removes them.
+	ArchiveDir = ".archive"
+	// JobKindReassign gives the files in a folder to its new owner.
+	JobKindReassign = "reassign"
+)
+
+func (s *Server) handleOrphans(writer http.ResponseWriter, req *http.Request) {
+	var orphanReq OrphanRequest
+	submitter, ok := s.readRequest(writer, req, &orphanReq)
+	if !ok {
+		return
+	}
+	if !s.requireAdmin(writer, submitter) {
+		return
+	}
+	status, output := s.attemptOrphan(req.Context(), orphanReq)
+	if status == http.StatusInternalServerError {
+		output += "\n\nTry again later and check whether the folder is in an unexpected state."
+	}
+	writer.WriteHeader(status)
+	_, _ = fmt.Fprintln(writer, output)
+}
+
+func (s *Server) attemptOrphan(ctx context.Context, orphanReq OrphanRequest) (statusCode int, output string) {
+	disabled, err := s.disabledUsers()
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to list disabled accounts: " + err.Error()
+	}
+	if orphanReq.Action == "list" {
+		return s.listOrphans(disabled)
+	}
+	switch orphanReq.Action {
+	case "reassign", "archive", "delete":
+	default:
+		return http.StatusBadRequest, fmt.Sprintf("Invalid action requested: %q", orphanReq.Action)
+	}
+	quotaFS, ok := s.Tiers[orphanReq.Tier]
+	if !ok {
+		return http.StatusBadRequest, fmt.Sprintf("Invalid tier requested: %q does not exist!", orphanReq.Tier)
+	}
+	name := orphanReq.Name
+	err = ValidateProjectName(name)
+	if err != nil {
+		return http.StatusBadRequest, "Invalid name requested: " + err.Error()
+	}
+	var newOwner *user.User
+	if orphanReq.Action == "reassign" {
+		newOwner, err = user.Lookup(orphanReq.User)
+		if err != nil {
+			return http.StatusBadRequest, "Cannot find requested user: " + err.Error()
+		}
+		if slices.Contains(disabled, newOwner.Username) {
+			return http.StatusBadRequest, "The account of " + newOwner.Username + " is disabled."
+		}
+	}
+
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+	defer s.refreshFolder(orphanReq.Tier, name)
+	owner, previousUID, err := folderOwnerUID(quotaFS, name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusBadRequest, "Folder " + name + " does not exist in " + orphanReq.Tier + "."
+	case err != nil:
+		return http.StatusInternalServerError, "Failed to fetch owner for folder: " + err.Error()
+	}
+	if owner != "" && !slices.Contains(disabled, owner) {
+		return http.StatusBadRequest, "Folder " + name + " belongs to " + owner + ", whose account is active."
+	}
+	migration, err := quotaFS.Metadata(name, MetadataMigration)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check folder migration status: " + err.Error()
+	}
+	if migration != "" || s.jobs.Active(orphanReq.Tier, name) {
+		return http.StatusBadRequest, "Another operation on the folder is in progress. Try again once it has completed."
+	}
+
+	switch orphanReq.Action {
+	case "reassign":
+		pool, err := quotaFS.Metadata(name, MetadataPool)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to check folder pool: " + err.Error()
+		}
+		charged, chargedToOwner := "their allocation", "your allocation"
+		if pool != "" {
+			// The folder stays charged to the pool, so the new owner's allocation is unaffected.
+			charged, chargedToOwner = "pool "+pool, "pool "+pool
+		} else if !orphanReq.Force {
+			folderQuota, err := quotaFS.Quota(name)
+			if err != nil {
+				return http.StatusInternalServerError, "Failed to fetch quota for folder: " + err.Error()
+			}
+			tierQuota, quotaUsed, err := s.chargedQuota(ctx, newOwner, orphanReq.Tier, "")
+			if err != nil {
+				return http.StatusInternalServerError, "Failed to calculate quota: " + err.Error()
+			}
+			if remainingQuota := tierQuota - quotaUsed; folderQuota > remainingQuota {
+				return http.StatusBadRequest, fmt.Sprintf(
+					"%s has %s used of %s in %s and %s left, but %s has a quota of %s.\n"+
+						"Shrink the folder or raise their allocation first, or set force to reassign it anyway.",
+					newOwner.Username, FormatByteSize(quotaUsed), FormatByteSize(tierQuota), orphanReq.Tier,
+					FormatByteSize(remainingQuota), name, FormatByteSize(folderQuota),
+				)
+			}
+		}
+		err = quotaFS.ChangeOwner(name, newOwner.Uid, newOwner.Gid)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to change owner of folder: " + err.Error()
+		}
+		err = s.ProjectFS.ReplaceLink(name, quotaFS.PathFor(name), newOwner.Uid, newOwner.Gid)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to change owner of project link: " + err.Error()
+		}
+		job, err := s.jobs.Submit(Job{
+			Kind:        JobKindReassign,
+			Owner:       newOwner.Username,
+			Tier:        orphanReq.Tier,
+			Folder:      name,
+			NewOwner:    newOwner.Username,
+			PreviousUID: previousUID,
+			Description: fmt.Sprintf("Giving the files in %s to %s", name, newOwner.Username),
+		})
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to start changing owner of files: " + err.Error()
+		}
+		s.notify(Event{
+			Kind:   EventFolderReassigned,
+			User:   newOwner.Username,
+			Tier:   orphanReq.Tier,
+			Folder: name,
+			Message: fmt.Sprintf(
+				"You are now the owner of the folder %s in %s, which is charged to %s.",
+				name, orphanReq.Tier, chargedToOwner,
+			),
+		})
+		return http.StatusOK, fmt.Sprintf(
+			"Folder %s now belongs to %s and is charged to %s.\n"+
+				"The files inside it are being given to them in the background (job %s).",
+			name, newOwner.Username, charged, job.ID,
+		)
+	case "archive":
+		archivePath, err := s.archiveFolder(orphanReq.Tier, name)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to archive folder: " + err.Error()
+		}
+		err = s.ProjectFS.DeleteLink(name)
+		if err != nil {
+			return http.StatusInternalServerError, "Folder has been archived but its link could not be deleted: " + err.Error()
+		}
+		return http.StatusOK, fmt.Sprintf(
+			"Folder %s has been archived to %s and is no longer charged to anyone.",
+			name, quotaFS.PathFor(archivePath),
+		)
+	default:
+		_, purgeAt, err := s.moveToTrash(orphanReq.Tier, name)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to delete folder: " + err.Error()
+		}
+		err = s.ProjectFS.DeleteLink(name)
+		if err != nil {
+			return http.StatusInternalServerError, "Folder has been deleted but its link could not be deleted: " + err.Error()
+		}
+		return http.StatusOK, fmt.Sprintf(
+			"Folder %s has been moved to the trash and will be purged after %s.",
+			name, purgeAt.Format(time.DateTime),
+		)
+	}
+}
+
+// disabledUsers returns the names of disabled accounts, or nothing if FreeIPA is not configured.
+func (s *Server) disabledUsers() ([]string, error) {
+	if s.IPA == nil {
+		return nil, nil
+	}
+	return s.IPA.DisabledUsers()
+}
+
+// listOrphans lists the folders whose owner no longer exists or is disabled, and the archived
+// folders.
+func (s *Server) listOrphans(disabled []string) (statusCode int, output string) {
+	var sb strings.Builder
+	for _, tierName := range slices.Sorted(maps.Keys(s.Tiers)) {
+		entries, _, err := s.folders(context.Background(), tierName, func(owner, _ string) bool {
+			return owner == "" || slices.Contains(disabled, owner)
+		})
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to list folders in " + tierName + ": " + err.Error()
+		}
+		for _, entry := range entries {
+			if _, ok := parseTrashPath(entry.Name); ok {
+				// The folder is purged once its retention has passed anyway.
+				continue
+			}
+			owner := entry.DescribeOwner()
+			if entry.Owner != "" {
+				owner += " (disabled account)"
+			}
+			_, _ = fmt.Fprintf(
+				&sb, "%s\t%s\t%s\t%s used / %s assigned\n",
+				tierName, entry.Name, owner, FormatByteSize(entry.Usage), FormatByteSize(entry.Quota),
+			)
+		}
+		archived, err := fs.ReadDir(s.Tiers[tierName], ArchiveDir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return http.StatusInternalServerError, "Failed to list archived folders in " + tierName + ": " + err.Error()
+		}
+		for _, entry := range archived {
+			_, _ = fmt.Fprintf(&sb, "%s\t%s\tarchived\n", tierName, path.Join(ArchiveDir, entry.Name()))
+		}
+	}
+	if sb.Len() == 0 {
+		return http.StatusOK, "There are no folders owned by deleted or disabled accounts."
+	}
+	return http.StatusOK, strings.TrimSuffix(sb.String(), "\n")
+}
+
+// folderOwnerUID returns the name and UID of the owner of the folder. The name is empty if the
+// owner no longer exists.
+func folderOwnerUID(quotaFS QuotaFS, name string) (string, int, error) {
+	owner, err := quotaFS.FileOwner(name)
+	var unknownOwner *UnknownOwnerError
+	if errors.As(err, &unknownOwner) {
+		return "", unknownOwner.UID, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	ownerInfo, err := user.Lookup(owner)
+	if err != nil {
+		return "", 0, fmt.Errorf("error looking up owner: %w", err)
+	}
+	uid, err := strconv.Atoi(ownerInfo.Uid)
+	if err != nil {
+		return "", 0, fmt.Errorf("error parsing UID of owner: %w", err)
+	}
+	return owner, uid, nil
+}
+
+// archiveFolder moves the folder into the archive of the tier and returns its new path. The
+// caller must hold updateMutex.
+func (s *Server) archiveFolder(tierName, name string) (string, error) {
+	quotaFS := s.Tiers[tierName]
+	_, err := fs.Stat(quotaFS, ArchiveDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Only root should be able to look inside the archive.
+		err = quotaFS.CreateFolder(ArchiveDir, "0", "0")
+	}
+	if err != nil {
+		return "", fmt.Errorf("error preparing archive: %w", err)
+	}
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	archivePath := path.Join(ArchiveDir, name+"."+id)
+	err = quotaFS.RenameFolder(name, archivePath)
+	if err != nil {
+		return "", fmt.Errorf("error moving folder to archive: %w", err)
+	}
+	return archivePath, nil
+}
+
+// runReassign gives every file in the folder owned by the previous owner to the new owner.
+func (s *Server) runReassign(ctx context.Context, job Job, progress func(done, total int)) error {
+	newOwner, err := user.Lookup(job.NewOwner)
+	if err != nil {
+		return fmt.Errorf("error looking up new owner: %w", err)
+	}
+	uid, err := strconv.Atoi(newOwner.Uid)
+	if err != nil {
+		return fmt.Errorf("error parsing UID of new owner: %w", err)
+	}
+	quotaFS, ok := s.Tiers[job.Tier]
+	if !ok {
+		return fmt.Errorf("tier %q no longer exists", job.Tier)
+	}
+	progress(0, 0)
+	folderPath := quotaFS.PathFor(job.Folder)
+	fd, err := unix.Open(folderPath, openDirFlags, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", folderPath, err)
+	}
+	defer func() { _ = unix.Close(fd) }()
+	return chownContents(ctx, fd, folderPath, job.PreviousUID, uid)
+}
+
+// chownContents changes the owner of every entry below the directory fd owned by fromUID to toUID.
+// Like copyTree, it only opens directories relative to their parent with O_NOFOLLOW.
+func chownContents(ctx context.Context, fd int, dirPath string, fromUID, toUID int) error {
+	names, err := readDirNames(fd, dirPath)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		entryPath := filepath.Join(dirPath, name)
+		var stat unix.Stat_t
+		err := unix.Fstatat(fd, name, &stat, unix.AT_SYMLINK_NOFOLLOW)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", entryPath, err)
+		}
+		if int(stat.Uid) == fromUID {
+			err := unix.Fchownat(fd, name, toUID, -1, unix.AT_SYMLINK_NOFOLLOW)
+			if err != nil {
+				return fmt.Errorf("error changing owner of %s: %w", entryPath, err)
+			}
+		}
+		if stat.Mode&unix.S_IFMT != unix.S_IFDIR {
+			continue
+		}
+		childFd, err := unix.Openat(fd, name, openDirFlags, 0)
+		if err != nil {
+			return fmt.Errorf("error opening %s: %w", entryPath, err)
+		}
+		err = chownContents(ctx, childFd, entryPath, fromUID, toUID)
+		_ = unix.Close(childFd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,8 +1,13 @@
 package storaged
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
+	"path"
+	"sync"
+	"time"
 )
 
 // QuotaUnbounded is returned if the directory is unbounded in quota. It is a
@@ -10,43 +15,173 @@ import (
 // limit.
 var QuotaUnbounded = 1 << 50
 
+// DefaultScanWorkers is the number of folders read concurrently by default.
+const DefaultScanWorkers = 8
+
+// MetadataPool is the metadata key holding the pool a folder is charged to.
+const MetadataPool = "pool"
+
 type Quota struct {
-	Name  string
+	Name string
+	// Owner is the name of the owner of the folder, or empty if their account no longer exists.
+	Owner string
+	// OrphanUID is the UID owning the folder if its account no longer exists.
+	OrphanUID int
+	// Pool is the pool the folder is charged to, or empty if it is charged to the owner.
+	Pool  string
 	Usage int
 	Quota int
+	// Expires is when the folder expires, or the zero time if it does not.
+	Expires time.Time
 }
 
-// QuotaUsed returns the quota allocation used by the user.
-func QuotaUsed(quotaFS QuotaFS, user string) ([]Quota, int, error) {
-	entries, err := fs.ReadDir(quotaFS, ".")
+// DescribeOwner returns the name of the owner of the folder, or its UID if the owner no longer
+// exists.
+func (q Quota) DescribeOwner() string {
+	if q.Owner == "" {
+		return fmt.Sprintf("UID %d (deleted account)", q.OrphanUID)
+	}
+	return q.Owner
+}
+
+// QuotaUsed returns the quota allocation used by the user. Folders charged to a pool are not
+// included.
+func QuotaUsed(ctx context.Context, quotaFS QuotaFS, user string) ([]Quota, int, error) {
+	return quotaMatching(ctx, quotaFS, DefaultScanWorkers, func(owner, pool string) bool {
+		return owner == user && pool == ""
+	})
+}
+
+// PoolUsed returns the quota allocation charged to the pool by all of its members.
+func PoolUsed(ctx context.Context, quotaFS QuotaFS, pool string) ([]Quota, int, error) {
+	return quotaMatching(ctx, quotaFS, DefaultScanWorkers, func(_, folderPool string) bool {
+		return folderPool == pool
+	})
+}
+
+// quotaMatching reads every folder in the tier, including those in the trash, using the specified
+// number of workers and returns the ones matching the filter along with the sum of their quota. It
+// stops early if ctx is cancelled.
+func quotaMatching(
+	ctx context.Context, quotaFS QuotaFS, workers int, match func(owner, pool string) bool,
+) ([]Quota, int, error) {
+	entries, err := folderNames(quotaFS)
 	if err != nil {
-		return nil, 0, fmt.Errorf("error reading directory entries: %w", err)
+		return nil, 0, err
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	names := make(chan int)
+	results := make([]*Quota, len(entries))
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range names {
+				result, err := readMatchingFolder(quotaFS, entries[i], match)
+				if err != nil {
+					cancel(err)
+					return
+				}
+				results[i] = result
+			}
+		}()
+	}
+feed:
+	for i := range entries {
+		select {
+		case names <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(names)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, 0, context.Cause(ctx)
 	}
 	quotaEntries := []Quota{}
 	quotaUsed := 0
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		quotaUsed += result.Quota
+		quotaEntries = append(quotaEntries, *result)
+	}
+	return quotaEntries, quotaUsed, nil
+}
+
+// folderNames returns the paths of every folder in the tier. Deleted folders stay charged to
+// their owner, so the folders in the trash are included.
+func folderNames(quotaFS QuotaFS) ([]string, error) {
+	entries, err := fs.ReadDir(quotaFS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory entries: %w", err)
+	}
+	names := make([]string, 0, len(entries))
 	for _, entry := range entries {
-		name := entry.Name()
-		owner, err := quotaFS.FileOwner(name)
-		if err != nil {
-			return nil, 0, fmt.Errorf("failed to get owner of %q: %w", name, err)
+		if entry.Name() == ArchiveDir {
+			// Archived folders are no longer charged to anyone.
+			continue
 		}
-		if owner != user {
+		if entry.Name() != TrashDir {
+			names = append(names, entry.Name())
 			continue
 		}
-		usage, err := quotaFS.Usage(name)
+		trashEntries, err := fs.ReadDir(quotaFS, TrashDir)
 		if err != nil {
-			return nil, 0, fmt.Errorf("failed to get usage assigned to %q: %w", name, err)
+			return nil, fmt.Errorf("error reading trash entries: %w", err)
 		}
-		quota, err := quotaFS.Quota(name)
-		if err != nil {
-			return nil, 0, fmt.Errorf("failed to get quota assigned to %q: %w", name, err)
+		for _, trashEntry := range trashEntries {
+			names = append(names, path.Join(TrashDir, trashEntry.Name()))
 		}
-		quotaUsed += quota
-		quotaEntries = append(quotaEntries, Quota{
-			Name:  name,
-			Usage: usage,
-			Quota: quota,
-		})
 	}
-	return quotaEntries, quotaUsed, nil
+	return names, nil
+}
+
+// readMatchingFolder returns the quota information of the folder, or nil if it does not match the
+// filter. Usage and quota are only read for matching folders.
+func readMatchingFolder(quotaFS QuotaFS, name string, match func(owner, pool string) bool) (*Quota, error) {
+	owner, err := quotaFS.FileOwner(name)
+	var unknownOwner *UnknownOwnerError
+	switch {
+	case errors.As(err, &unknownOwner):
+		// Report the folder as orphaned rather than failing the whole tier.
+	case err != nil:
+		return nil, fmt.Errorf("failed to get owner of %q: %w", name, err)
+	}
+	pool, err := quotaFS.Metadata(name, MetadataPool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool of %q: %w", name, err)
+	}
+	if !match(owner, pool) {
+		return nil, nil
+	}
+	usage, err := quotaFS.Usage(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage assigned to %q: %w", name, err)
+	}
+	quota, err := quotaFS.Quota(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota assigned to %q: %w", name, err)
+	}
+	expires, err := folderExpiry(quotaFS, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expiry of %q: %w", name, err)
+	}
+	orphanUID := 0
+	if unknownOwner != nil {
+		orphanUID = unknownOwner.UID
+	}
+	return &Quota{
+		Name:      name,
+		Owner:     owner,
+		OrphanUID: orphanUID,
+		Pool:      pool,
+		Usage:     usage,
+		Quota:     quota,
+		Expires:   expires,
+	}, nil
 }
@@ -7,16 +7,27 @@ import (
 	"os"
 	"os/user"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/sys/unix"
 )
 
+// metadataXattrPrefix is the namespace storaged metadata is stored in. Only privileged processes
+// can modify trusted xattrs, so users cannot tamper with the metadata of their own folders.
+const metadataXattrPrefix = "trusted.storaged."
+
+// ownerCacheTTL is how long the name of a UID is cached for. It is kept short so that accounts
+// deleted from the directory are noticed in reasonable time.
+const ownerCacheTTL = 10 * time.Minute
+
 // CephFS is an implementation of QuotaFS based on actual Ceph filesystem.
 type CephFS struct {
 	rootFS
+	owners *ownerCache
 }
 
 type rootFS fs.FS
@@ -30,20 +41,53 @@ func NewCephFS() (*CephFS, error) {
 	}
 	return &CephFS{
 		rootFS: rootFS.FS(),
+		owners: &ownerCache{names: make(map[uint32]cachedOwner)},
 	}, nil
 }
 
+// ownerCache caches the user name for each UID as user.LookupId goes through sssd and is slow
+// when done for every folder in a tier.
+type ownerCache struct {
+	mutex sync.Mutex
+	names map[uint32]cachedOwner
+}
+
+type cachedOwner struct {
+	name    string
+	expires time.Time
+}
+
+func (c *ownerCache) lookup(uid uint32) (string, error) {
+	c.mutex.Lock()
+	cached, ok := c.names[uid]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.name, nil
+	}
+	userInfo, err := user.LookupId(strconv.Itoa(int(uid)))
+	if errors.As(err, new(user.UnknownUserIdError)) {
+		return "", &UnknownOwnerError{UID: int(uid)}
+	}
+	if err != nil {
+		return "", err
+	}
+	c.mutex.Lock()
+	c.names[uid] = cachedOwner{name: userInfo.Username, expires: time.Now().Add(ownerCacheTTL)}
+	c.mutex.Unlock()
+	return userInfo.Username, nil
+}
+
 func (fs CephFS) FileOwner(filePath string) (string, error) {
 	var output unix.Stat_t
 	err := unix.Stat("/"+filePath, &output)
 	if err != nil {
 		return "", fmt.Errorf("error getting file stat: %w", err)
 	}
-	userInfo, err := user.LookupId(strconv.Itoa(int(output.Uid)))
+	userName, err := fs.owners.lookup(output.Uid)
 	if err != nil {
 		return "", fmt.Errorf("error getting info for owner of %s: %w", filePath, err)
 	}
-	return userInfo.Username, nil
+	return userName, nil
 }
 
 func (fs CephFS) Usage(filePath string) (int, error) {
@@ -105,6 +149,33 @@ func (fs CephFS) SetQuota(filePath string, maxBytes int) error {
 	return unix.Setxattr("/"+filePath, "ceph.quota.max_bytes", []byte(strconv.Itoa(maxBytes)), 0)
 }
 
+func (fs CephFS) Metadata(filePath, key string) (string, error) {
+	var output [256]byte
+	sz, err := unix.Getxattr("/"+filePath, metadataXattrPrefix+key, output[:])
+	switch {
+	case errors.Is(err, unix.ENODATA):
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("error getting xattr: %w", err)
+	}
+	return string(output[:sz]), nil
+}
+
+func (fs CephFS) SetMetadata(filePath, key, value string) error {
+	if value == "" {
+		err := unix.Removexattr("/"+filePath, metadataXattrPrefix+key)
+		if err != nil && !errors.Is(err, unix.ENODATA) {
+			return fmt.Errorf("error removing xattr: %w", err)
+		}
+		return nil
+	}
+	err := unix.Setxattr("/"+filePath, metadataXattrPrefix+key, []byte(value), 0)
+	if err != nil {
+		return fmt.Errorf("error setting xattr: %w", err)
+	}
+	return nil
+}
+
 func (fs CephFS) CreateLink(filePath string, absoluteTarget string, uid, gid string) error {
 	uidNum, err := strconv.Atoi(uid)
 	if err != nil {
@@ -128,6 +199,21 @@ func (fs CephFS) CreateLink(filePath string, absoluteTarget string, uid, gid str
 	return nil
 }
 
+func (fs CephFS) ReplaceLink(filePath string, absoluteTarget string, uid, gid string) error {
+	// Project names cannot contain dots, so the temporary link never collides with a project.
+	tmpPath := filePath + ".storaged-tmp"
+	err := fs.CreateLink(tmpPath, absoluteTarget, uid, gid)
+	if err != nil {
+		return err
+	}
+	err = os.Rename("/"+tmpPath, "/"+filePath)
+	if err != nil {
+		_ = os.Remove("/" + tmpPath)
+		return fmt.Errorf("failed to replace symlink: %w", err)
+	}
+	return nil
+}
+
 func (fs CephFS) DeleteLink(filePath string) error {
 	err := os.Remove("/" + filePath)
 	if err != nil {
@@ -165,6 +251,22 @@ func (cephFS CephFS) CreateFolder(filePath string, uid, gid string) error {
 	return nil
 }
 
+func (cephFS CephFS) ChangeOwner(filePath string, uid, gid string) error {
+	uidNum, err := strconv.Atoi(uid)
+	if err != nil {
+		return fmt.Errorf("error parsing UID %q: %w", uid, err)
+	}
+	gidNum, err := strconv.Atoi(gid)
+	if err != nil {
+		return fmt.Errorf("error parsing GID %q: %w", gid, err)
+	}
+	err = os.Lchown("/"+filePath, uidNum, gidNum)
+	if err != nil {
+		return fmt.Errorf("error chown-ing folder: %w", err)
+	}
+	return nil
+}
+
 func (cephFS CephFS) DeleteFolder(filePath string) error {
 	err := os.Remove("/" + filePath)
 	if err != nil {
@@ -173,6 +275,75 @@ func (cephFS CephFS) DeleteFolder(filePath string) error {
 	return nil
 }
 
+func (cephFS CephFS) RenameFolder(filePath, newFilePath string) error {
+	// rename(2) silently replaces an empty directory, so check for it ourselves.
+	_, err := os.Lstat("/" + newFilePath)
+	switch {
+	case err == nil:
+		return fmt.Errorf("error renaming directory: %w", fs.ErrExist)
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("error checking rename destination: %w", err)
+	}
+	err = os.Rename("/"+filePath, "/"+newFilePath)
+	if err != nil {
+		return fmt.Errorf("error renaming directory: %w", err)
+	}
+	return nil
+}
+
+func (cephFS CephFS) RemoveAll(filePath string) error {
+	if path.Clean("/"+filePath) == "/" {
+		return errors.New("refusing to remove root directory")
+	}
+	err := os.RemoveAll("/" + filePath)
+	if err != nil {
+		return fmt.Errorf("error removing directory recursively: %w", err)
+	}
+	return nil
+}
+
+// cephSnapDir is the virtual directory CephFS exposes snapshots of a directory in.
+const cephSnapDir = ".snap"
+
+func (cephFS CephFS) Snapshots(filePath string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(path.Join("/", filePath, cephSnapDir))
+	if err != nil {
+		return nil, fmt.Errorf("error listing snapshots: %w", err)
+	}
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		// Snapshots of parent directories and those taken by hand are listed too, skip them.
+		snapshot, ok := ParseSnapshotName(entry.Name())
+		if ok {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	slices.SortFunc(snapshots, func(a, b Snapshot) int {
+		return a.Created.Compare(b.Created)
+	})
+	return snapshots, nil
+}
+
+func (cephFS CephFS) CreateSnapshot(filePath string, snapshot Snapshot) error {
+	err := os.Mkdir(path.Join("/", filePath, cephSnapDir, snapshot.Name), 0o755)
+	if err != nil {
+		return fmt.Errorf("error creating snapshot: %w", err)
+	}
+	return nil
+}
+
+func (cephFS CephFS) DeleteSnapshot(filePath, name string) error {
+	err := os.Remove(path.Join("/", filePath, cephSnapDir, name))
+	if err != nil {
+		return fmt.Errorf("error deleting snapshot: %w", err)
+	}
+	return nil
+}
+
+func (cephFS CephFS) SnapshotPath(filePath, name string) string {
+	return path.Join("/", filePath, cephSnapDir, name)
+}
+
 func (cephFS CephFS) PathFor(filePath string) string {
 	return path.Clean("/" + filePath)
 }
@@ -0,0 +1,319 @@
+package storaged
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"os/user"
+	"path"
+	"slices"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// fakeFolder is a folder of a fakeQuotaFS.
+type fakeFolder struct {
+	owner    string
+	quota    int
+	usage    int
+	metadata map[string]string
+}
+
+// fakeQuotaFS is an in-memory QuotaFS. Operations can be made to fail by adding the method name
+// and path, e.g. "SetQuota proj1", to failures.
+type fakeQuotaFS struct {
+	root string
+
+	mutex    sync.Mutex
+	folders  map[string]*fakeFolder
+	links    map[string]string
+	failures map[string]error
+}
+
+var _ QuotaFS = (*fakeQuotaFS)(nil)
+
+func newFakeQuotaFS(root string) *fakeQuotaFS {
+	return &fakeQuotaFS{
+		root:     root,
+		folders:  make(map[string]*fakeFolder),
+		links:    make(map[string]string),
+		failures: make(map[string]error),
+	}
+}
+
+// fail makes the method fail for the path until it is cleared with a nil error.
+func (f *fakeQuotaFS) fail(method, project string, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err == nil {
+		delete(f.failures, method+" "+project)
+		return
+	}
+	f.failures[method+" "+project] = err
+}
+
+// folder returns a copy of the folder at the path, or nil if it does not exist.
+func (f *fakeQuotaFS) folder(project string) *fakeFolder {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	folder, ok := f.folders[project]
+	if !ok {
+		return nil
+	}
+	copied := *folder
+	copied.metadata = maps.Clone(folder.metadata)
+	return &copied
+}
+
+// addFolder creates a folder owned by the user with the quota and usage.
+func (f *fakeQuotaFS) addFolder(project, owner string, quota, usage int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.folders[project] = &fakeFolder{owner: owner, quota: quota, usage: usage, metadata: make(map[string]string)}
+}
+
+// lookup returns the folder at the path. The caller must hold mutex.
+func (f *fakeQuotaFS) lookup(method, project string) (*fakeFolder, error) {
+	if err := f.failures[method+" "+project]; err != nil {
+		return nil, err
+	}
+	folder, ok := f.folders[project]
+	if !ok {
+		return nil, fmt.Errorf("%s %s: %w", method, project, fs.ErrNotExist)
+	}
+	return folder, nil
+}
+
+func (f *fakeQuotaFS) Open(name string) (fs.File, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	mapFS := make(fstest.MapFS)
+	for project := range f.folders {
+		mapFS[project] = &fstest.MapFile{Mode: fs.ModeDir | 0o755}
+	}
+	for project, target := range f.links {
+		mapFS[project] = &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink | 0o777}
+	}
+	return mapFS.Open(name)
+}
+
+func (f *fakeQuotaFS) Usage(project string) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	folder, err := f.lookup("Usage", project)
+	if err != nil {
+		return 0, err
+	}
+	return folder.usage, nil
+}
+
+func (f *fakeQuotaFS) Quota(project string) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	folder, err := f.lookup("Quota", project)
+	if err != nil {
+		return 0, err
+	}
+	return folder.quota, nil
+}
+
+func (f *fakeQuotaFS) SetQuota(project string, newQuota int) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	folder, err := f.lookup("SetQuota", project)
+	if err != nil {
+		return err
+	}
+	folder.quota = newQuota
+	return nil
+}
+
+func (f *fakeQuotaFS) FileOwner(project string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	folder, err := f.lookup("FileOwner", project)
+	if err != nil {
+		return "", err
+	}
+	return folder.owner, nil
+}
+
+func (f *fakeQuotaFS) Metadata(project, key string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	folder, err := f.lookup("Metadata", project)
+	if err != nil {
+		return "", err
+	}
+	return folder.metadata[key], nil
+}
+
+func (f *fakeQuotaFS) SetMetadata(project, key, value string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	folder, err := f.lookup("SetMetadata", project)
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		delete(folder.metadata, key)
+	} else {
+		folder.metadata[key] = value
+	}
+	return nil
+}
+
+func (f *fakeQuotaFS) CreateFolder(project string, uid string, _ string) error {
+	owner, err := user.LookupId(uid)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.failures["CreateFolder "+project]; err != nil {
+		return err
+	}
+	if _, ok := f.folders[project]; ok {
+		return fmt.Errorf("CreateFolder %s: %w", project, fs.ErrExist)
+	}
+	f.folders[project] = &fakeFolder{owner: owner.Username, metadata: make(map[string]string)}
+	return nil
+}
+
+func (f *fakeQuotaFS) ChangeOwner(project string, uid string, _ string) error {
+	owner, err := user.LookupId(uid)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	folder, err := f.lookup("ChangeOwner", project)
+	if err != nil {
+		return err
+	}
+	folder.owner = owner.Username
+	return nil
+}
+
+func (f *fakeQuotaFS) DeleteFolder(project string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	folder, err := f.lookup("DeleteFolder", project)
+	if err != nil {
+		return err
+	}
+	if folder.usage != 0 {
+		return fmt.Errorf("DeleteFolder %s: directory not empty", project)
+	}
+	delete(f.folders, project)
+	return nil
+}
+
+func (f *fakeQuotaFS) RenameFolder(project, newProject string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	folder, err := f.lookup("RenameFolder", project)
+	if err != nil {
+		return err
+	}
+	if _, ok := f.folders[newProject]; ok {
+		return fmt.Errorf("RenameFolder %s: %w", newProject, fs.ErrExist)
+	}
+	delete(f.folders, project)
+	f.folders[newProject] = folder
+	return nil
+}
+
+func (f *fakeQuotaFS) RemoveAll(project string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.failures["RemoveAll "+project]; err != nil {
+		return err
+	}
+	delete(f.folders, project)
+	return nil
+}
+
+func (f *fakeQuotaFS) CreateLink(project, absoluteTarget string, _, _ string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.failures["CreateLink "+project]; err != nil {
+		return err
+	}
+	if _, ok := f.links[project]; ok {
+		return fmt.Errorf("CreateLink %s: %w", project, fs.ErrExist)
+	}
+	f.links[project] = absoluteTarget
+	return nil
+}
+
+func (f *fakeQuotaFS) ReplaceLink(project, absoluteTarget string, _, _ string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.failures["ReplaceLink "+project]; err != nil {
+		return err
+	}
+	f.links[project] = absoluteTarget
+	return nil
+}
+
+func (f *fakeQuotaFS) DeleteLink(project string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.failures["DeleteLink "+project]; err != nil {
+		return err
+	}
+	if _, ok := f.links[project]; !ok {
+		return fmt.Errorf("DeleteLink %s: %w", project, fs.ErrNotExist)
+	}
+	delete(f.links, project)
+	return nil
+}
+
+func (f *fakeQuotaFS) PathFor(project string) string {
+	return path.Join(f.root, project)
+}
+
+// link returns the target of the link, or the empty string if it does not exist.
+func (f *fakeQuotaFS) link(project string) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.links[project]
+}
+
+// names returns the paths of all folders.
+func (f *fakeQuotaFS) names() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return slices.Sorted(maps.Keys(f.folders))
+}
+
+// testServer returns a server with a single tier "hdd" and state in a temporary directory. The
+// current user is allocated maxBytes in the tier through their primary group.
+func testServer(t *testing.T, tier QuotaFS, maxBytes int, configure func(*ServerConfig)) (*Server, *user.User) {
+	t.Helper()
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := ServerConfig{
+		ProjectFS:   newFakeQuotaFS("/project"),
+		Tiers:       map[string]QuotaFS{"hdd": tier},
+		Allocations: map[string][]Allocation{group.Name: {{Tier: "hdd", MaxBytes: maxBytes}}},
+		StateDir:    t.TempDir(),
+		Notifier:    LogNotifier{},
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, current
+}
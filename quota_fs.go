@@ -6,6 +6,16 @@ import (
 	"path"
 )
 
+// UnknownOwnerError is returned when the owner of a file no longer exists, e.g. because their
+// account has been deleted.
+type UnknownOwnerError struct {
+	UID int
+}
+
+func (e *UnknownOwnerError) Error() string {
+	return fmt.Sprintf("owner with UID %d no longer exists", e.UID)
+}
+
 type QuotaFS interface {
 	fs.FS
 
@@ -15,15 +25,31 @@ type QuotaFS interface {
 	Quota(project string) (int, error)
 	// SetQuota sets the Quota for the file path.
 	SetQuota(project string, newQuota int) error
-	// FileOwner returns the name of the owner of the file.
+	// FileOwner returns the name of the owner of the file. It returns an UnknownOwnerError if the
+	// owner no longer exists.
 	FileOwner(project string) (string, error)
+	// Metadata returns the storaged metadata stored under key for the project, or an empty string
+	// if it has not been set.
+	Metadata(project, key string) (string, error)
+	// SetMetadata sets the storaged metadata stored under key for the project. An empty value
+	// removes the key.
+	SetMetadata(project, key, value string) error
 
 	// CreateFolder creates the specified folder.
 	CreateFolder(project string, uid string, gid string) error
+	// ChangeOwner changes the owner of the specified folder, but not of anything inside it.
+	ChangeOwner(project string, uid string, gid string) error
 	// DeleteFolder deletes the specified folder.
 	DeleteFolder(project string) error
+	// RenameFolder renames the specified folder. It fails if newProject already exists.
+	RenameFolder(project, newProject string) error
+	// RemoveAll deletes the specified folder along with everything in it.
+	RemoveAll(project string) error
 	// NewLink creates a link from the project name to the specified location.
 	CreateLink(project, absoluteTarget string, uid, gid string) error
+	// ReplaceLink atomically points an existing link from the project name to the specified
+	// location.
+	ReplaceLink(project, absoluteTarget string, uid, gid string) error
 	// DeleteLink deletes a link from the project name.
 	DeleteLink(project string) error
 
@@ -77,6 +103,20 @@ func (f *subQuotaFS) FileOwner(filepath string) (string, error) {
 	return f.original.FileOwner(path.Join(f.path, filepath))
 }
 
+func (f *subQuotaFS) Metadata(filepath, key string) (string, error) {
+	if !fs.ValidPath(filepath) {
+		return "", fmt.Errorf("cannot get metadata of invalid path %s", filepath)
+	}
+	return f.original.Metadata(path.Join(f.path, filepath), key)
+}
+
+func (f *subQuotaFS) SetMetadata(filepath, key, value string) error {
+	if !fs.ValidPath(filepath) {
+		return fmt.Errorf("cannot set metadata of invalid path %s", filepath)
+	}
+	return f.original.SetMetadata(path.Join(f.path, filepath), key, value)
+}
+
 func (f *subQuotaFS) CreateFolder(filepath, uid, gid string) error {
 	if !fs.ValidPath(filepath) {
 		return fmt.Errorf("cannot create folder of invalid path %s", filepath)
@@ -84,6 +124,13 @@ func (f *subQuotaFS) CreateFolder(filepath, uid, gid string) error {
 	return f.original.CreateFolder(path.Join(f.path, filepath), uid, gid)
 }
 
+func (f *subQuotaFS) ChangeOwner(filepath, uid, gid string) error {
+	if !fs.ValidPath(filepath) || filepath == "." {
+		return fmt.Errorf("cannot change owner of invalid path %s", filepath)
+	}
+	return f.original.ChangeOwner(path.Join(f.path, filepath), uid, gid)
+}
+
 func (f *subQuotaFS) DeleteFolder(filepath string) error {
 	if !fs.ValidPath(filepath) {
 		return fmt.Errorf("cannot delete folder of invalid path %s", filepath)
@@ -91,6 +138,23 @@ func (f *subQuotaFS) DeleteFolder(filepath string) error {
 	return f.original.DeleteFolder(path.Join(f.path, filepath))
 }
 
+func (f *subQuotaFS) RenameFolder(filepath, newFilepath string) error {
+	if !fs.ValidPath(filepath) || filepath == "." {
+		return fmt.Errorf("cannot rename folder of invalid path %s", filepath)
+	}
+	if !fs.ValidPath(newFilepath) || newFilepath == "." {
+		return fmt.Errorf("cannot rename folder to invalid path %s", newFilepath)
+	}
+	return f.original.RenameFolder(path.Join(f.path, filepath), path.Join(f.path, newFilepath))
+}
+
+func (f *subQuotaFS) RemoveAll(filepath string) error {
+	if !fs.ValidPath(filepath) || filepath == "." {
+		return fmt.Errorf("cannot delete folder of invalid path %s", filepath)
+	}
+	return f.original.RemoveAll(path.Join(f.path, filepath))
+}
+
 func (f *subQuotaFS) CreateLink(filepath, absoluteTarget, uid, gid string) error {
 	if !fs.ValidPath(filepath) {
 		return fmt.Errorf("cannot create link of invalid path %s", filepath)
@@ -98,6 +162,13 @@ func (f *subQuotaFS) CreateLink(filepath, absoluteTarget, uid, gid string) error
 	return f.original.CreateLink(path.Join(f.path, filepath), absoluteTarget, uid, gid)
 }
 
+func (f *subQuotaFS) ReplaceLink(filepath, absoluteTarget, uid, gid string) error {
+	if !fs.ValidPath(filepath) {
+		return fmt.Errorf("cannot replace link of invalid path %s", filepath)
+	}
+	return f.original.ReplaceLink(path.Join(f.path, filepath), absoluteTarget, uid, gid)
+}
+
 func (f *subQuotaFS) DeleteLink(filepath string) error {
 	if !fs.ValidPath(filepath) {
 		return fmt.Errorf("cannot create link of invalid path %s", filepath)
@@ -105,6 +176,47 @@ func (f *subQuotaFS) DeleteLink(filepath string) error {
 	return f.original.DeleteLink(path.Join(f.path, filepath))
 }
 
+func (f *subQuotaFS) Snapshots(filepath string) ([]Snapshot, error) {
+	snapFS, ok := f.original.(SnapshotFS)
+	if !ok {
+		return nil, ErrSnapshotsUnsupported
+	}
+	if !fs.ValidPath(filepath) {
+		return nil, fmt.Errorf("cannot list snapshots of invalid path %s", filepath)
+	}
+	return snapFS.Snapshots(path.Join(f.path, filepath))
+}
+
+func (f *subQuotaFS) CreateSnapshot(filepath string, snapshot Snapshot) error {
+	snapFS, ok := f.original.(SnapshotFS)
+	if !ok {
+		return ErrSnapshotsUnsupported
+	}
+	if !fs.ValidPath(filepath) {
+		return fmt.Errorf("cannot create snapshot of invalid path %s", filepath)
+	}
+	return snapFS.CreateSnapshot(path.Join(f.path, filepath), snapshot)
+}
+
+func (f *subQuotaFS) DeleteSnapshot(filepath, name string) error {
+	snapFS, ok := f.original.(SnapshotFS)
+	if !ok {
+		return ErrSnapshotsUnsupported
+	}
+	if !fs.ValidPath(filepath) {
+		return fmt.Errorf("cannot delete snapshot of invalid path %s", filepath)
+	}
+	return snapFS.DeleteSnapshot(path.Join(f.path, filepath), name)
+}
+
+func (f *subQuotaFS) SnapshotPath(project, name string) string {
+	snapFS, ok := f.original.(SnapshotFS)
+	if !ok {
+		return ""
+	}
+	return snapFS.SnapshotPath(path.Join(f.path, project), name)
+}
+
 func (f *subQuotaFS) PathFor(project string) string {
 	return f.original.PathFor(path.Join(f.path, project))
 }
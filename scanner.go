@@ -0,0 +1,162 @@
+package storaged
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+const thresholdStateFile = "thresholds.json"
+
+// DefaultScanInterval is how often folders are scanned by default.
+const DefaultScanInterval = 15 * time.Minute
+
+// DefaultSoftThresholds are the percentages of a folder's quota at which its owner is warned by
+// default.
+var DefaultSoftThresholds = []int{80, 95}
+
+// scanFolders periodically goes through every folder in every tier to rebuild the folder index,
+// find folders that crossed a soft threshold and update the usage metrics.
+func (s *Server) scanFolders() {
+	for tierName, quotaFS := range s.Tiers {
+		scanStart := time.Now()
+		entries, _, err := quotaMatching(
+			context.Background(), quotaFS, s.scanWorkers(), func(_, _ string) bool { return true },
+		)
+		if err != nil {
+			log.Printf("failed to scan folders in %s: %v", tierName, err)
+			continue
+		}
+		s.index.Replace(tierName, entries, scanStart)
+		s.checkThresholds(tierName, entries)
+		s.metrics.updateUsage(tierName, entries)
+	}
+}
+
+// softThreshold returns the highest soft threshold crossed by the folder, or 0 if none are.
+func (s *Server) softThreshold(usage, quota int) int {
+	if quota <= 0 || quota >= QuotaUnbounded {
+		return 0
+	}
+	thresholds := s.SoftThresholds
+	if thresholds == nil {
+		thresholds = DefaultSoftThresholds
+	}
+	crossed := 0
+	for _, threshold := range thresholds {
+		// Compare in floating point as usage*100 may overflow for unbounded-ish values.
+		if float64(usage)*100 >= float64(quota)*float64(threshold) {
+			crossed = max(crossed, threshold)
+		}
+	}
+	return crossed
+}
+
+// checkThresholds notifies the owner of every folder that crossed a higher soft threshold since
+// the last scan.
+func (s *Server) checkThresholds(tierName string, entries []Quota) {
+	levels := make(map[string]int, len(entries))
+	for _, entry := range entries {
+		if _, ok := parseTrashPath(entry.Name); ok || entry.Owner == "" {
+			continue
+		}
+		level := s.softThreshold(entry.Usage, entry.Quota)
+		if level == 0 {
+			continue
+		}
+		levels[entry.Name] = level
+		if level <= s.thresholds.Get(tierName, entry.Name) {
+			continue
+		}
+		s.notify(Event{
+			Kind:   EventThresholdCrossed,
+			User:   entry.Owner,
+			Tier:   tierName,
+			Folder: entry.Name,
+			Bytes:  entry.Usage,
+			Message: fmt.Sprintf(
+				"Your folder %s in %s is %d%% full (%s used / %s assigned). "+
+					"Delete some files or increase its quota before it fills up.",
+				entry.Name, tierName, level, FormatByteSize(entry.Usage), FormatByteSize(entry.Quota),
+			),
+		})
+	}
+	err := s.thresholds.Update(tierName, levels)
+	if err != nil {
+		log.Printf("failed to record soft thresholds in %s: %v", tierName, err)
+	}
+}
+
+// thresholdStore remembers the soft threshold each folder was last seen at so that owners are
+// only notified once per crossing.
+type thresholdStore struct {
+	mutex  sync.Mutex
+	path   string
+	levels map[string]map[string]int
+}
+
+func loadThresholdStore(statePath string) (*thresholdStore, error) {
+	store := &thresholdStore{path: statePath, levels: make(map[string]map[string]int)}
+	err := loadState(statePath, &store.levels)
+	if err != nil {
+		return nil, fmt.Errorf("error loading soft thresholds: %w", err)
+	}
+	return store, nil
+}
+
+// Get returns the threshold the folder was last seen at.
+func (t *thresholdStore) Get(tier, folder string) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.levels[tier][folder]
+}
+
+// Update replaces the thresholds of the folders in the tier.
+func (t *thresholdStore) Update(tier string, levels map[string]int) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if maps.Equal(t.levels[tier], levels) {
+		return nil
+	}
+	previous := t.levels[tier]
+	t.levels[tier] = levels
+	err := saveState(t.path, t.levels)
+	if err != nil {
+		t.levels[tier] = previous
+		return fmt.Errorf("error saving soft thresholds: %w", err)
+	}
+	return nil
+}
+
+// Rename moves the threshold of a folder in the tier to its new name.
+func (t *thresholdStore) Rename(tier, folder, newFolder string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	level, ok := t.levels[tier][folder]
+	if !ok {
+		return nil
+	}
+	previous := t.levels[tier]
+	renamed := maps.Clone(previous)
+	delete(renamed, folder)
+	renamed[newFolder] = level
+	t.levels[tier] = renamed
+	err := saveState(t.path, t.levels)
+	if err != nil {
+		t.levels[tier] = previous
+		return fmt.Errorf("error saving soft thresholds: %w", err)
+	}
+	return nil
+}
+
+// validateSoftThresholds returns an error if any threshold is not a sensible percentage.
+func validateSoftThresholds(thresholds []int) error {
+	if slices.ContainsFunc(thresholds, func(v int) bool { return v <= 0 || v > 100 }) {
+		return fmt.Errorf("soft thresholds must be between 1 and 100, got %v", thresholds)
+	}
+	return nil
+}
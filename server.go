@@ -2,6 +2,7 @@ package storaged
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -21,29 +22,214 @@ type Server struct {
 	limiterMutex sync.Mutex
 	limiter      map[int]clientLimit
 	updateMutex  sync.Mutex
+	grants       *grantStore
+	grace        *graceStore
+	thresholds   *thresholdStore
+	metrics      *metrics
+	index        *folderIndex
+	history      *historyStore
+	jobs         *jobTracker
+	approvals    *approvalStore
+	idempotency  *idempotencyStore
 }
 
-func NewServer(cfg ServerConfig) *Server {
-	return &Server{
+func NewServer(cfg ServerConfig) (*Server, error) {
+	s := &Server{
 		ServerConfig: cfg,
 		limiterMutex: sync.Mutex{},
 		limiter:      make(map[int]clientLimit),
 		updateMutex:  sync.Mutex{},
+		metrics:      newMetrics(),
+		index:        newFolderIndex(),
 	}
+	err := validateSoftThresholds(cfg.SoftThresholds)
+	if err != nil {
+		return nil, err
+	}
+	for tierName, policy := range cfg.SnapshotPolicies {
+		if _, ok := cfg.Tiers[tierName].(SnapshotFS); !ok {
+			return nil, fmt.Errorf("tier %q does not support snapshots", tierName)
+		}
+		err := policy.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot policy for tier %q: %w", tierName, err)
+		}
+	}
+	for tierName, policy := range cfg.ExpiryPolicies {
+		if _, ok := cfg.Tiers[tierName]; !ok {
+			return nil, fmt.Errorf("expiry policy for unknown tier %q", tierName)
+		}
+		err := policy.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry policy for tier %q: %w", tierName, err)
+		}
+	}
+	for tierName, policy := range cfg.ApprovalPolicies {
+		if _, ok := cfg.Tiers[tierName]; !ok {
+			return nil, fmt.Errorf("approval policy for unknown tier %q", tierName)
+		}
+		err := policy.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid approval policy for tier %q: %w", tierName, err)
+		}
+	}
+	for _, allocations := range []map[string][]Allocation{cfg.Allocations, cfg.Pools} {
+		for group, groupAllocations := range allocations {
+			for _, allocation := range groupAllocations {
+				if allocation.Approval == nil {
+					continue
+				}
+				err := allocation.Approval.Validate()
+				if err != nil {
+					return nil, fmt.Errorf(
+						"invalid approval policy for allocation of %q in %q: %w", group, allocation.Tier, err,
+					)
+				}
+			}
+		}
+	}
+	s.grants, err = loadGrantStore(s.statePath(grantStateFile))
+	if err != nil {
+		return nil, err
+	}
+	s.grace, err = loadGraceStore(s.statePath(graceStateFile))
+	if err != nil {
+		return nil, err
+	}
+	s.thresholds, err = loadThresholdStore(s.statePath(thresholdStateFile))
+	if err != nil {
+		return nil, err
+	}
+	s.history, err = loadHistoryStore(s.statePath(historyStateFile))
+	if err != nil {
+		return nil, err
+	}
+	s.approvals, err = loadApprovalStore(s.statePath(approvalStateFile))
+	if err != nil {
+		return nil, err
+	}
+	s.idempotency, err = loadIdempotencyStore(s.statePath(idempotencyStateFile), cfg.IdempotencyTTL)
+	if err != nil {
+		return nil, err
+	}
+	s.jobs, err = loadJobTracker(s.statePath(jobStateFile), cfg.MaxJobsPerUser, s.runJob)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 type ServerConfig struct {
 	AllowedEncodeHost *net.IPNet
+	// MetricsAllowedHost is the network that may scrape /metrics. If nil, any host may.
+	MetricsAllowedHost *net.IPNet
+	// MetricsUserUsage adds the usage of every user to the metrics. It is off by default because
+	// the metrics are not authenticated.
+	MetricsUserUsage bool
 
 	ProjectFS QuotaFS
 	Tiers     map[string]QuotaFS
 	// Allocations is the map from the group name to the Allocation the user is entitled to.
 	Allocations map[string][]Allocation
+	// Pools is the map from the group name to the Allocation shared jointly by all members of the
+	// group. Folders charged to a pool do not count towards the owner's own allocation.
+	Pools map[string][]Allocation
+	// AdminGroups is the list of groups whose members may use the administrative API.
+	AdminGroups []string
+
+	// StateDir is the directory persistent state such as grants is stored in. If empty, state is
+	// kept in memory and lost on restart.
+	StateDir string
+	// GrantExpiryWarning is how long before a temporary grant expires its user is notified.
+	GrantExpiryWarning time.Duration
+	// GracePeriod is how long a user may be assigned more quota than they are allocated, e.g.
+	// after leaving a group, before GracePolicy is enforced.
+	GracePeriod time.Duration
+	// GracePolicy is what happens to the folders of a user once their grace period has ended.
+	GracePolicy GracePolicy
+	// MaxBreakdownDepth is the maximum depth of subdirectories users may request a usage breakdown
+	// for.
+	MaxBreakdownDepth int
+	// ScanWorkers is the number of folders read concurrently when scanning a tier.
+	ScanWorkers int
+	// SoftThresholds are the percentages of a folder's quota at which its owner is warned. If nil,
+	// DefaultSoftThresholds is used.
+	SoftThresholds []int
+	// ScanInterval is how often every folder is scanned to rebuild the folder index used for quota
+	// lookups and to check for crossed soft thresholds.
+	ScanInterval time.Duration
+	// HistoryInterval is how often the usage and quota of every folder is recorded.
+	HistoryInterval time.Duration
+	// HistoryRawRetention is how long every recorded sample is kept before being downsampled to one
+	// sample per day.
+	HistoryRawRetention time.Duration
+	// HistoryRetention is how long recorded samples are kept at all.
+	HistoryRetention time.Duration
+	// ForecastWindow is how much recent history is used to predict the growth of a folder.
+	ForecastWindow time.Duration
+	// ForecastHorizon is how soon a folder has to be predicted to fill up before it is highlighted
+	// in the quota report.
+	ForecastHorizon time.Duration
+	// ForecastModel is the model fitted to the history of a folder.
+	ForecastModel ForecastModel
+	// Notifier delivers events to users. If nil, events are only logged.
+	Notifier Notifier
+	// TrashRetention is how long deleted folders are kept in the trash before they are purged.
+	TrashRetention time.Duration
+	// FreezeTrash shrinks the quota of deleted folders to their usage so that only the space they
+	// use stays charged while they are in the trash.
+	FreezeTrash bool
+	// MaxJobsPerUser is the number of background jobs a user may have running at once. Further
+	// jobs are queued.
+	MaxJobsPerUser int
+	// SnapshotPolicies is the map from the tier name to how the folders in it are snapshotted.
+	// Snapshots are disabled in tiers without a policy.
+	SnapshotPolicies map[string]SnapshotPolicy
+	// ExpiryPolicies is the map from the tier name to the rules for the expiry dates of the folders
+	// in it. Folders in tiers without a policy may still be given an expiry date by their owner.
+	ExpiryPolicies map[string]ExpiryPolicy
+	// ApprovalPolicies is the map from the tier name to the folder size above which creating or
+	// growing a folder in it needs approval. Allocations may require approval of their own.
+	ApprovalPolicies map[string]ApprovalPolicy
+	// IdempotencyTTL is how long the response to a request with an idempotency key is kept. If
+	// zero, DefaultIdempotencyTTL is used.
+	IdempotencyTTL time.Duration
+	// IPA is used to rename the project group of a folder along with the folder. If nil, project
+	// groups are left untouched.
+	IPA *IPAClient
 }
 
 type Allocation struct {
 	Tier     string `toml:"tier"`
 	MaxBytes int    `toml:"max_bytes"`
+	// Policy decides how the allocation combines with other allocations for the same tier.
+	Policy AllocationPolicy `toml:"policy"`
+	// Approval requires large folders charged to the allocation to be approved. If nil, only the
+	// policy of the tier applies.
+	Approval *ApprovalPolicy `toml:"approval"`
+}
+
+type AllocationPolicy string
+
+const (
+	// AllocationPolicyMax only grants the largest of all max allocations for the tier. It is the
+	// default if no policy is specified.
+	AllocationPolicyMax AllocationPolicy = "max"
+	// AllocationPolicySum stacks the allocation on top of all other allocations for the tier.
+	AllocationPolicySum AllocationPolicy = "sum"
+	// AllocationPolicyOverride replaces all other allocations for the tier. If multiple overrides
+	// apply, the largest one is used.
+	AllocationPolicyOverride AllocationPolicy = "override"
+)
+
+// Validate returns an error if the policy is not known.
+func (p AllocationPolicy) Validate() error {
+	switch p {
+	case "", AllocationPolicyMax, AllocationPolicySum, AllocationPolicyOverride:
+		return nil
+	default:
+		return fmt.Errorf("unknown allocation policy %q", p)
+	}
 }
 
 type clientLimit struct {
@@ -53,14 +239,45 @@ type clientLimit struct {
 
 func (s *Server) Listen(address string) error {
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /quota", s.handleCheckQuota)
-	mux.HandleFunc("POST /folders", s.handleUpdateFolder)
+	mux.HandleFunc("POST /quota", s.instrument("quota", s.handleCheckQuota))
+	mux.HandleFunc("POST /folders", s.instrument("folders", s.idempotent(s.handleUpdateFolder)))
+	mux.HandleFunc("POST /admin/grants", s.instrument("admin_grants", s.idempotent(s.handleGrants)))
+	mux.HandleFunc("POST /admin/orphans", s.instrument("admin_orphans", s.idempotent(s.handleOrphans)))
+	mux.HandleFunc("POST /approvals", s.instrument("approvals", s.idempotent(s.handleApprovals)))
+	mux.HandleFunc("POST /jobs", s.instrument("jobs", s.handleJobs))
+	mux.HandleFunc("GET /jobs/{id}", s.instrument("jobs_get", s.handleJob))
+	mux.HandleFunc("POST /jobs/{id}/cancel", s.instrument("jobs_cancel", s.handleCancelJob))
+	mux.HandleFunc("POST /folders/batch", s.instrument("folders_batch", s.idempotent(s.handleBatch)))
+	mux.HandleFunc("POST /folders/restore", s.instrument("folders_restore", s.idempotent(s.handleRestoreFolder)))
+	mux.HandleFunc("POST /folders/rename", s.instrument("folders_rename", s.idempotent(s.handleRenameFolder)))
+	mux.HandleFunc("POST /folders/migrate", s.instrument("folders_migrate", s.idempotent(s.handleMigrateFolder)))
+	mux.HandleFunc("POST /folders/snapshots", s.instrument("folders_snapshots", s.idempotent(s.handleSnapshots)))
+	mux.HandleFunc("POST /folders/breakdown", s.instrument("folders_breakdown", s.handleBreakdown))
+	mux.HandleFunc("POST /history", s.instrument("history", s.handleHistory))
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	s.jobs.Resume()
+	go every(time.Hour, s.expireGrants)
+	go every(time.Hour, s.checkGracePeriods)
+	go every(time.Hour, s.purgeTrash)
+	go every(snapshotCheckInterval, s.takeScheduledSnapshots)
+	go every(time.Hour, s.expireFolders)
+	go every(cmp.Or(s.ScanInterval, DefaultScanInterval), s.scanFolders)
+	go every(cmp.Or(s.HistoryInterval, DefaultHistoryInterval), s.recordHistory)
 	if err := http.ListenAndServe(address, mux); err != nil {
 		return fmt.Errorf("error listening: %w", err)
 	}
 	return nil
 }
 
+// every calls fn every interval for the rest of the process's lifetime, starting immediately.
+func every(interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	for {
+		fn()
+		<-ticker.C
+	}
+}
+
 func (s *Server) readRequest(writer http.ResponseWriter, req *http.Request, dest any) (submitter *user.User, ok bool) {
 	// We have bigger issues if the request is larger than 1MB.
 	reqBody, err := io.ReadAll(io.LimitReader(req.Body, 1024*1024))
@@ -70,6 +287,7 @@ func (s *Server) readRequest(writer http.ResponseWriter, req *http.Request, dest
 	}
 	mungeOutput, err := Unmunge(string(reqBody))
 	if err != nil {
+		s.metrics.incUnmungeFailures()
 		http.Error(writer, "Failed to authenticate request: "+err.Error(), http.StatusUnauthorized)
 		return nil, false
 	}
@@ -100,6 +318,7 @@ func (s *Server) readRequest(writer http.ResponseWriter, req *http.Request, dest
 	ok = s.limiter[uid].limiter.Allow()
 	s.limiterMutex.Unlock()
 	if !ok {
+		s.metrics.incRateLimited()
 		http.Error(
 			writer,
 			"You have sent too many requests recently. Please slow down.",
@@ -127,5 +346,8 @@ func (s *Server) readRequest(writer http.ResponseWriter, req *http.Request, dest
 		)
 		return nil, false
 	}
+	if !s.checkIdempotency(writer, req, uid, mungeOutput.Payload) {
+		return nil, false
+	}
 	return submitter, true
 }
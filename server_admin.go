@@ -0,0 +1,116 @@
+package storaged
+
+import (
+	"fmt"
+	"net/http"
+	"os/user"
+	"strings"
+	"time"
+)
+
+func (s *Server) handleGrants(writer http.ResponseWriter, req *http.Request) {
+	var grantReq GrantRequest
+	submitter, ok := s.readRequest(writer, req, &grantReq)
+	if !ok {
+		return
+	}
+	if !s.requireAdmin(writer, submitter) {
+		return
+	}
+	status, output := s.attemptGrant(submitter, grantReq)
+	writer.WriteHeader(status)
+	_, _ = fmt.Fprintln(writer, output)
+}
+
+// requireAdmin writes an error and returns false if the submitter is not an administrator.
+func (s *Server) requireAdmin(writer http.ResponseWriter, submitter *user.User) bool {
+	isAdmin, err := s.isAdmin(submitter)
+	if err != nil {
+		http.Error(writer, "Failed to check administrator status: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !isAdmin {
+		http.Error(writer, "You are not allowed to perform administrative actions.", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (s *Server) attemptGrant(submitter *user.User, grantReq GrantRequest) (statusCode int, output string) {
+	switch grantReq.Action {
+	case "list":
+		grants := s.grants.List(grantReq.User)
+		if len(grants) == 0 {
+			return http.StatusOK, "There are no grants."
+		}
+		var sb strings.Builder
+		for _, grant := range grants {
+			_, _ = fmt.Fprintf(&sb, "%s\t%s\t%s", grant.ID, grant.User, grant.Describe())
+			if grant.Reason != "" {
+				_, _ = fmt.Fprintf(&sb, "\t(%s)", grant.Reason)
+			}
+			sb.WriteString("\n")
+		}
+		return http.StatusOK, strings.TrimSuffix(sb.String(), "\n")
+	case "add":
+		if _, err := user.Lookup(grantReq.User); err != nil {
+			return http.StatusBadRequest, "Cannot find requested user: " + err.Error()
+		}
+		if _, ok := s.Tiers[grantReq.Tier]; !ok {
+			return http.StatusBadRequest, fmt.Sprintf("Invalid tier requested: %q does not exist!", grantReq.Tier)
+		}
+		sizeInBytes := grantReq.SizeInGB * 1000 * 1000 * 1000
+		if sizeInBytes <= 0 || sizeInBytes < grantReq.SizeInGB {
+			return http.StatusBadRequest, "Provided grant size is invalid."
+		}
+		var expires time.Time
+		if grantReq.ExpiresOn != "" {
+			lastDay, err := time.ParseInLocation(time.DateOnly, grantReq.ExpiresOn, time.Local)
+			if err != nil {
+				return http.StatusBadRequest, "Provided expiry date is invalid: " + err.Error()
+			}
+			// The grant applies for the entirety of the last day.
+			expires = lastDay.AddDate(0, 0, 1)
+			if !expires.After(time.Now()) {
+				return http.StatusBadRequest, "Provided expiry date is in the past."
+			}
+		}
+		grant, err := s.grants.Add(Grant{
+			User:      grantReq.User,
+			Tier:      grantReq.Tier,
+			Bytes:     sizeInBytes,
+			Override:  grantReq.Override,
+			Expires:   expires,
+			Reason:    grantReq.Reason,
+			CreatedBy: submitter.Username,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to add grant: " + err.Error()
+		}
+		s.notify(Event{
+			Kind:     EventAllocationChanged,
+			User:     grant.User,
+			Tier:     grant.Tier,
+			Bytes:    grant.Bytes,
+			Deadline: grant.Expires,
+			Message:  fmt.Sprintf("You have been granted %s of storage.", grant.Describe()),
+		})
+		return http.StatusOK, fmt.Sprintf("Grant %s has been added: %s.", grant.ID, grant.Describe())
+	case "remove":
+		grant, err := s.grants.Remove(grantReq.ID)
+		if err != nil {
+			return http.StatusBadRequest, "Failed to remove grant: " + err.Error()
+		}
+		s.notify(Event{
+			Kind:    EventAllocationChanged,
+			User:    grant.User,
+			Tier:    grant.Tier,
+			Bytes:   grant.Bytes,
+			Message: fmt.Sprintf("Your storage grant of %s has been revoked.", grant.Describe()),
+		})
+		return http.StatusOK, fmt.Sprintf("Grant %s has been removed.", grant.ID)
+	default:
+		return http.StatusBadRequest, fmt.Sprintf("Unknown action %q.", grantReq.Action)
+	}
+}
@@ -0,0 +1,296 @@
+package storaged
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"os/user"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxBatchOperations is the largest number of operations accepted in a single batch.
+const maxBatchOperations = 100
+
+// batchCharge identifies the allocation that folders are charged to.
+type batchCharge struct {
+	tier string
+	pool string
+}
+
+func (s *Server) handleBatch(writer http.ResponseWriter, req *http.Request) {
+	var batchReq BatchRequest
+	submitter, ok := s.readRequest(writer, req, &batchReq)
+	if !ok {
+		return
+	}
+	if len(batchReq.Operations) == 0 {
+		http.Error(writer, "The batch has no operations.", http.StatusBadRequest)
+		return
+	}
+	if len(batchReq.Operations) > maxBatchOperations {
+		http.Error(
+			writer,
+			fmt.Sprintf("A batch may have at most %d operations.", maxBatchOperations),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	seen := make(map[string]bool)
+	for _, op := range batchReq.Operations {
+		msg := s.validateUpdate(op)
+		switch {
+		case msg != "":
+		case op.Permanent:
+			msg = "Permanent deletions cannot be undone, so they cannot be part of a batch."
+		case op.DryRun || op.IdempotencyKey != "":
+			msg = "Set dry_run and idempotency_key on the batch instead of its operations."
+		case seen[op.Name]:
+			// Project names are unique across tiers.
+			msg = "The folder is changed more than once."
+		}
+		if msg != "" {
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprintf(writer, "%s: %s\n", describeOperation(op), msg)
+			return
+		}
+		seen[op.Name] = true
+	}
+	status, output := s.attemptBatch(req.Context(), submitter, batchReq)
+	if status == http.StatusInternalServerError {
+		output += "\n\nTry again later and contact administrators if the folders are in an unexpected state."
+	}
+	writer.WriteHeader(status)
+	_, _ = fmt.Fprintln(writer, output)
+}
+
+// attemptBatch applies every operation in the batch or none of them. The allocation is only
+// checked for the end result, so a folder can grow by as much as others in the batch shrink.
+func (s *Server) attemptBatch(
+	ctx context.Context, submitter *user.User, batchReq BatchRequest,
+) (statusCode int, output string) {
+	groups, err := groupNames(submitter)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to find groups of user: " + err.Error()
+	}
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+	now := time.Now()
+	var updates []folderUpdate
+	var unchanged []string
+	for _, op := range batchReq.Operations {
+		defer s.refreshFolder(op.Tier, op.Name)
+		update, status, output := s.planUpdate(submitter, groups, op, now)
+		switch status {
+		case 0:
+			updates = append(updates, update)
+		case http.StatusOK:
+			unchanged = append(unchanged, describeOperation(op)+": "+output)
+		default:
+			return status, fmt.Sprintf("%s: %s\nNothing has been changed.", describeOperation(op), output)
+		}
+	}
+
+	changes := make(map[batchCharge]int)
+	for _, update := range updates {
+		changes[batchCharge{update.Tier, update.pool}] += update.chargeChange()
+	}
+	charges := slices.SortedFunc(maps.Keys(changes), func(a, b batchCharge) int {
+		return cmp.Or(strings.Compare(a.tier, b.tier), strings.Compare(a.pool, b.pool))
+	})
+	var chargeDescs []string
+	for _, charge := range charges {
+		tierQuota, quotaUsed, err := s.chargedQuota(ctx, submitter, charge.tier, charge.pool)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to calculate quota: " + err.Error()
+		}
+		remainingQuota := tierQuota - quotaUsed
+		if changes[charge] > 0 && remainingQuota < changes[charge] {
+			charged := "your allocation"
+			if charge.pool != "" {
+				charged = "pool " + charge.pool
+			}
+			return http.StatusBadRequest, fmt.Sprintf(
+				"You do not have sufficient quota left in %s for this batch.\n"+
+					"%s has %s used of %s and %s left.\n"+
+					"The batch needs %s more.\nNothing has been changed.",
+				charge.tier, strings.ToUpper(charged[:1])+charged[1:], FormatByteSize(quotaUsed),
+				FormatByteSize(tierQuota), FormatByteSize(remainingQuota), FormatByteSize(changes[charge]),
+			)
+		}
+		chargeDescs = append(
+			chargeDescs, describeCharge(charge.tier, charge.pool, tierQuota, quotaUsed, quotaUsed+changes[charge]),
+		)
+	}
+	for _, update := range updates {
+		if update.chargeChange() <= 0 {
+			continue
+		}
+		approvers := s.requiredApprovers(groups, update.Tier, update.pool, update.quotaRequested)
+		if len(approvers) > 0 {
+			return http.StatusBadRequest, fmt.Sprintf(
+				"%s: Folders of this size need to be approved by a member of %s.\n"+
+					"Request it on its own instead of as part of a batch.\nNothing has been changed.",
+				describeOperation(update.UpdateRequest), strings.Join(approvers, ", "),
+			)
+		}
+	}
+
+	var b strings.Builder
+	if batchReq.DryRun {
+		_, _ = fmt.Fprintln(&b, "This is a dry run, nothing has been changed.")
+		for _, update := range updates {
+			_, _ = fmt.Fprintln(&b, describeChange(update))
+		}
+		for _, desc := range unchanged {
+			_, _ = fmt.Fprintln(&b, desc)
+		}
+		for _, desc := range chargeDescs {
+			_, _ = fmt.Fprintln(&b, desc)
+		}
+		return http.StatusOK, strings.TrimSuffix(b.String(), "\n")
+	}
+
+	var undos []func() error
+	for _, update := range updates {
+		undo, err := s.applyBatchUpdate(submitter, update)
+		if err == nil {
+			undos = append(undos, undo)
+			continue
+		}
+		slices.Reverse(undos)
+		var undoErrs []error
+		for _, undo := range undos {
+			undoErrs = append(undoErrs, undo())
+		}
+		undoErr := errors.Join(undoErrs...)
+		if undoErr != nil {
+			log.Printf("failed to undo batch of %s: %v", submitter.Username, undoErr)
+			return http.StatusInternalServerError, fmt.Sprintf(
+				"%s: Failed to apply operation: %s\nThe operations before it could not all be undone: %s",
+				describeOperation(update.UpdateRequest), err, undoErr,
+			)
+		}
+		return http.StatusInternalServerError, fmt.Sprintf(
+			"%s: Failed to apply operation: %s\nThe operations before it have been undone.",
+			describeOperation(update.UpdateRequest), err,
+		)
+	}
+	_, _ = fmt.Fprintf(&b, "All %d operations have been applied.", len(batchReq.Operations))
+	for _, update := range updates {
+		s.notifyUpdate(submitter, update, now.Add(s.trashRetention()))
+		_, _ = fmt.Fprintf(&b, "\n%s: %s", describeOperation(update.UpdateRequest), describeApplied(update))
+	}
+	for _, desc := range unchanged {
+		_, _ = fmt.Fprintf(&b, "\n%s", desc)
+	}
+	return http.StatusOK, b.String()
+}
+
+// applyBatchUpdate applies an update that is part of a batch and returns a function that reverts
+// it if a later update in the batch fails. If the update itself fails, whatever part of it was
+// applied is reverted before returning.
+func (s *Server) applyBatchUpdate(submitter *user.User, update folderUpdate) (undo func() error, err error) {
+	quotaFS := s.Tiers[update.Tier]
+	switch {
+	case update.quotaRequested == 0:
+		var trashed trashedFolder
+		trashed, _, err = s.trashFolder(update)
+		if trashed.Path == "" {
+			return nil, err
+		}
+		undo = func() error {
+			defer s.refreshFolder(update.Tier, trashed.Path)
+			err := quotaFS.RenameFolder(trashed.Path, update.Name)
+			if err != nil {
+				return fmt.Errorf("error restoring %s from trash: %w", update.Name, err)
+			}
+			err = errors.Join(
+				quotaFS.SetMetadata(update.Name, MetadataDeleted, ""),
+				quotaFS.SetMetadata(update.Name, MetadataFrozenQuota, ""),
+				quotaFS.SetQuota(update.Name, update.currentQuota),
+				ignoreNotExist(s.ProjectFS.DeleteLink(update.Name)),
+				s.ProjectFS.CreateLink(update.Name, quotaFS.PathFor(update.Name), submitter.Uid, submitter.Gid),
+			)
+			if err != nil {
+				return fmt.Errorf("error restoring %s from trash: %w", update.Name, err)
+			}
+			return nil
+		}
+	case update.currentQuota == 0:
+		undo = func() error {
+			err := errors.Join(
+				ignoreNotExist(s.ProjectFS.DeleteLink(update.Name)),
+				ignoreNotExist(quotaFS.DeleteFolder(update.Name)),
+			)
+			if err != nil {
+				return fmt.Errorf("error removing created folder %s: %w", update.Name, err)
+			}
+			return nil
+		}
+		err = s.setFolderQuota(submitter, update)
+	default:
+		previousExpires, err := quotaFS.Metadata(update.Name, MetadataExpires)
+		if err != nil {
+			return nil, fmt.Errorf("error reading expiry date: %w", err)
+		}
+		undo = func() error {
+			err := errors.Join(
+				quotaFS.SetQuota(update.Name, update.currentQuota),
+				quotaFS.SetMetadata(update.Name, MetadataExpires, previousExpires),
+			)
+			if err != nil {
+				return fmt.Errorf("error reverting %s: %w", update.Name, err)
+			}
+			return nil
+		}
+		if update.currentQuota == update.quotaRequested {
+			err = s.setFolderExpiry(update)
+		} else {
+			err = s.setFolderQuota(submitter, update)
+		}
+		if err != nil {
+			return nil, errors.Join(err, undo())
+		}
+		return undo, nil
+	}
+	if err != nil {
+		return nil, errors.Join(err, undo())
+	}
+	return undo, nil
+}
+
+// ignoreNotExist returns nil if err only reports that something does not exist.
+func ignoreNotExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// describeOperation names the folder an operation of a batch applies to.
+func describeOperation(op UpdateRequest) string {
+	return op.Name + " in " + op.Tier
+}
+
+// describeApplied returns what an applied update of a batch changed.
+func describeApplied(update folderUpdate) string {
+	switch {
+	case update.quotaRequested == 0:
+		return "moved to the trash"
+	case update.currentQuota == 0:
+		return "created with a quota of " + FormatByteSize(update.quotaRequested)
+	case update.currentQuota == update.quotaRequested:
+		return "kept until " + describeExpiry(update.expires)
+	default:
+		return fmt.Sprintf(
+			"quota changed from %s to %s", FormatByteSize(update.currentQuota), FormatByteSize(update.quotaRequested),
+		)
+	}
+}
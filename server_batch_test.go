@@ -0,0 +1,115 @@
+package storaged
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os/user"
+	"strings"
+	"testing"
+)
+
+const gigabyte = 1000 * 1000 * 1000
+
+// batchTest returns a server whose current user is allocated allocatedGB in tier "hdd" and owns
+// the specified folders, each with a link in the project directory.
+func batchTest(
+	t *testing.T, allocatedGB int, quotasInGB map[string]int,
+) (*Server, *user.User, *fakeQuotaFS, *fakeQuotaFS) {
+	t.Helper()
+	tier := newFakeQuotaFS("/hdd")
+	s, current := testServer(t, tier, allocatedGB*gigabyte, nil)
+	projectFS := s.ProjectFS.(*fakeQuotaFS)
+	for name, quota := range quotasInGB {
+		tier.addFolder(name, current.Username, quota*gigabyte, 0)
+		err := projectFS.CreateLink(name, tier.PathFor(name), current.Uid, current.Gid)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, current, tier, projectFS
+}
+
+// batch returns a batch of the operations.
+func batch(ops ...UpdateRequest) BatchRequest {
+	return BatchRequest{Operations: ops}
+}
+
+func checkQuota(t *testing.T, tier *fakeQuotaFS, name string, wantGB int) {
+	t.Helper()
+	folder := tier.folder(name)
+	switch {
+	case folder == nil && wantGB != 0:
+		t.Errorf("%s does not exist, want a quota of %d GB", name, wantGB)
+	case folder != nil && wantGB == 0:
+		t.Errorf("%s exists with a quota of %d", name, folder.quota)
+	case folder != nil && folder.quota != wantGB*gigabyte:
+		t.Errorf("%s has a quota of %d, want %d GB", name, folder.quota, wantGB)
+	}
+}
+
+func TestBatchAllocation(t *testing.T) {
+	s, current, tier, _ := batchTest(t, 10, map[string]int{"a": 5, "b": 4})
+
+	status, output := s.attemptBatch(context.Background(), current, batch(
+		UpdateRequest{Name: "b", Tier: "hdd", SizeInGB: 7},
+	))
+	if status != http.StatusBadRequest || !strings.Contains(output, "sufficient quota left in hdd") {
+		t.Fatalf("growing beyond the allocation: %d %s", status, output)
+	}
+	checkQuota(t, tier, "b", 4)
+
+	// Shrinking a folder makes room for growing another in the same batch.
+	status, output = s.attemptBatch(context.Background(), current, batch(
+		UpdateRequest{Name: "b", Tier: "hdd", SizeInGB: 7},
+		UpdateRequest{Name: "a", Tier: "hdd", SizeInGB: 2},
+	))
+	if status != http.StatusOK {
+		t.Fatalf("growing by as much as shrinking: %d %s", status, output)
+	}
+	checkQuota(t, tier, "a", 2)
+	checkQuota(t, tier, "b", 7)
+
+	status, output = s.attemptBatch(context.Background(), current, batch(
+		UpdateRequest{Name: "a", Tier: "hdd", SizeInGB: 1},
+		UpdateRequest{Name: "c", Tier: "hdd", SizeInGB: 3},
+	))
+	if status != http.StatusBadRequest || !strings.Contains(output, "needs 2.0 GB more") {
+		t.Fatalf("growing by more than shrinking: %d %s", status, output)
+	}
+	checkQuota(t, tier, "a", 2)
+	checkQuota(t, tier, "c", 0)
+}
+
+func TestBatchUndo(t *testing.T) {
+	s, current, tier, projectFS := batchTest(t, 20, map[string]int{"a": 5, "b": 4, "e": 1})
+	tier.fail("SetQuota", "e", errors.New("quota server unavailable"))
+
+	status, output := s.attemptBatch(context.Background(), current, batch(
+		UpdateRequest{Name: "a", Tier: "hdd", SizeInGB: 3},
+		UpdateRequest{Name: "c", Tier: "hdd", SizeInGB: 2},
+		UpdateRequest{Name: "b", Tier: "hdd", SizeInGB: 0},
+		UpdateRequest{Name: "e", Tier: "hdd", SizeInGB: 2},
+	))
+	if status != http.StatusInternalServerError || !strings.Contains(output, "have been undone") {
+		t.Fatalf("failing batch: %d %s", status, output)
+	}
+	checkQuota(t, tier, "a", 5)
+	checkQuota(t, tier, "c", 0)
+	checkQuota(t, tier, "b", 4)
+	checkQuota(t, tier, "e", 1)
+	if projectFS.link("c") != "" {
+		t.Error("link of created folder c was not removed")
+	}
+	if projectFS.link("b") != tier.PathFor("b") {
+		t.Errorf("link of restored folder b points to %q", projectFS.link("b"))
+	}
+	if deleted := tier.folder("b").metadata[MetadataDeleted]; deleted != "" {
+		t.Errorf("restored folder b is still marked as deleted at %s", deleted)
+	}
+	for _, name := range tier.names() {
+		if _, ok := parseTrashPath(name); ok {
+			t.Errorf("%s was left in the trash", name)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package storaged
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os/user"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	// DefaultBreakdownDepth is how many levels of subdirectories are listed by default.
+	DefaultBreakdownDepth = 1
+	// DefaultMaxBreakdownDepth is the maximum depth users may request by default.
+	DefaultMaxBreakdownDepth = 3
+	// DefaultBreakdownLimit is how many subdirectories are listed by default.
+	DefaultBreakdownLimit = 20
+	// maxBreakdownVisited bounds the number of directories visited for a single breakdown so that
+	// folders with millions of directories do not overload the MDS.
+	maxBreakdownVisited = 10000
+)
+
+func (s *Server) handleBreakdown(writer http.ResponseWriter, req *http.Request) {
+	var breakdownReq BreakdownRequest
+	submitter, ok := s.readRequest(writer, req, &breakdownReq)
+	if !ok {
+		return
+	}
+	if _, ok := s.Tiers[breakdownReq.Tier]; !ok {
+		http.Error(writer, fmt.Sprintf("Invalid tier requested: %q does not exist!", breakdownReq.Tier), http.StatusBadRequest)
+		return
+	}
+	err := ValidateProjectName(breakdownReq.Name)
+	if err != nil {
+		http.Error(writer, "Invalid name requested: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxDepth := cmp.Or(s.MaxBreakdownDepth, DefaultMaxBreakdownDepth)
+	depth := cmp.Or(breakdownReq.Depth, DefaultBreakdownDepth)
+	if depth < 1 || depth > maxDepth {
+		http.Error(writer, fmt.Sprintf("Depth must be between 1 and %d.", maxDepth), http.StatusBadRequest)
+		return
+	}
+	limit := cmp.Or(breakdownReq.Limit, DefaultBreakdownLimit)
+	if limit < 1 {
+		http.Error(writer, "Limit must be positive.", http.StatusBadRequest)
+		return
+	}
+	status, output := s.attemptBreakdown(submitter, breakdownReq.Tier, breakdownReq.Name, depth, limit)
+	writer.WriteHeader(status)
+	_, _ = fmt.Fprintln(writer, output)
+}
+
+func (s *Server) attemptBreakdown(
+	submitter *user.User, tierName, name string, depth, limit int,
+) (statusCode int, output string) {
+	quotaFS := s.Tiers[tierName]
+	owner, err := quotaFS.FileOwner(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusBadRequest, "Folder " + name + " does not exist in " + tierName + "."
+	case err != nil:
+		return http.StatusInternalServerError, "Failed to fetch owner for folder: " + err.Error()
+	}
+	if owner != submitter.Username {
+		isAdmin, err := s.isAdmin(submitter)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to check administrator status: " + err.Error()
+		}
+		if !isAdmin {
+			return http.StatusBadRequest, "The folder does not belong to you!"
+		}
+	}
+	total, err := quotaFS.Usage(name)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to calculate usage for folder: " + err.Error()
+	}
+	entries, truncated, err := usageBreakdown(quotaFS, name, total, depth)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to break down folder usage: " + err.Error()
+	}
+	slices.SortStableFunc(entries, func(a, b dirUsage) int {
+		return b.Usage - a.Usage
+	})
+	omitted := len(entries) > limit
+	if omitted {
+		entries = entries[:limit]
+	}
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "Folder %s is using %s in total.\n\n", name, FormatByteSize(total))
+	for _, entry := range entries {
+		_, _ = fmt.Fprintf(&sb, "%10s  %s\n", FormatByteSize(entry.Usage), entry.Path)
+	}
+	if omitted {
+		_, _ = fmt.Fprintln(&sb, "\nSmaller directories have been omitted for brevity.")
+	}
+	if truncated {
+		_, _ = fmt.Fprintln(&sb, "\nThe folder has too many directories, so only part of it has been listed.")
+	}
+	return http.StatusOK, strings.TrimSuffix(sb.String(), "\n")
+}
+
+type dirUsage struct {
+	// Path is the path of the directory relative to the folder.
+	Path  string
+	Usage int
+}
+
+// usageBreakdown returns the recursive usage of every directory in the folder, whose usage is
+// total, up to the specified depth. Usage comes from the recursive statistics of each directory so files are never walked.
+// Files directly inside a listed directory are reported as a single entry. It returns true if it
+// stopped early because too many directories were visited.
+func usageBreakdown(quotaFS QuotaFS, name string, total int, depth int) ([]dirUsage, bool, error) {
+	var result []dirUsage
+	visited := 0
+	var walk func(dir string, dirUsageTotal int, remainingDepth int) (bool, error)
+	walk = func(dir string, dirUsageTotal int, remainingDepth int) (bool, error) {
+		entries, err := fs.ReadDir(quotaFS, dir)
+		if err != nil {
+			return false, fmt.Errorf("error reading %s: %w", dir, err)
+		}
+		subdirTotal := 0
+		for _, entry := range entries {
+			// Symlinks are not directories, so we never leave the folder.
+			if !entry.IsDir() {
+				continue
+			}
+			visited++
+			if visited > maxBreakdownVisited {
+				return true, nil
+			}
+			subdir := path.Join(dir, entry.Name())
+			usage, err := quotaFS.Usage(subdir)
+			if err != nil {
+				return false, fmt.Errorf("error getting usage of %s: %w", subdir, err)
+			}
+			subdirTotal += usage
+			result = append(result, dirUsage{Path: "." + strings.TrimPrefix(subdir, name) + "/", Usage: usage})
+			if remainingDepth > 1 {
+				truncated, err := walk(subdir, usage, remainingDepth-1)
+				if truncated || err != nil {
+					return truncated, err
+				}
+			}
+		}
+		if filesUsage := dirUsageTotal - subdirTotal; filesUsage > 0 {
+			label := "(files in ." + strings.TrimPrefix(dir, name) + "/)"
+			result = append(result, dirUsage{Path: label, Usage: filesUsage})
+		}
+		return false, nil
+	}
+	truncated, err := walk(name, total, depth)
+	if err != nil {
+		return nil, false, err
+	}
+	return result, truncated, nil
+}
@@ -1,11 +1,14 @@
 package storaged
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	"net/http"
 	"os/user"
 	"slices"
 	"strings"
+	"time"
 )
 
 const MaxDisplayedFolderPerTier = 5
@@ -36,8 +39,8 @@ func (s *Server) handleCheckQuota(writer http.ResponseWriter, req *http.Request)
 	}
 	outputEntries := make([]quotaEntry, 0, len(s.Tiers))
 	folderOmitted := false
-	for tierName, quotaFS := range s.Tiers {
-		entries, usedQuota, err := QuotaUsed(quotaFS, checkReq.User)
+	for tierName := range s.Tiers {
+		entries, usedQuota, err := s.quotaUsed(req.Context(), tierName, checkReq.User)
 		if err != nil {
 			http.Error(
 				writer,
@@ -67,23 +70,98 @@ func (s *Server) handleCheckQuota(writer http.ResponseWriter, req *http.Request)
 	slices.SortFunc(outputEntries, func(a, b quotaEntry) int {
 		return strings.Compare(a.Name, b.Name)
 	})
-	if len(outputEntries) == 0 {
+	poolEntries, err := s.poolEntries(req.Context(), checkTarget)
+	if err != nil {
+		http.Error(
+			writer,
+			"Failed to retrieve pool usage: "+err.Error(),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	if len(outputEntries) == 0 && len(poolEntries) == 0 {
 		_, _ = fmt.Fprintf(writer, "User %s has no access to managed storage.\n", checkReq.User)
 		return
 	}
 	_, _ = fmt.Fprintf(writer, "User %s has access to the following tiers of storage:\n\n", checkReq.User)
+	now := time.Now()
 	for _, v := range outputEntries {
 		_, _ = fmt.Fprintf(
 			writer,
 			"%s - %s assigned / %s allocated\n",
 			v.Name, FormatByteSize(v.UsedQuota), FormatByteSize(v.AllowedQuota),
 		)
+		if record, ok := s.grace.Get(checkTarget.Username, v.Name); ok {
+			_, _ = fmt.Fprintf(writer, "\t⚠ %s\n", record.Describe(now))
+		}
 		for _, w := range v.UsageEntries {
+			if folder, ok := parseTrashPath(w.Name); ok {
+				_, _ = fmt.Fprintf(
+					writer,
+					"\t%s (deleted, ID %s%s) - %s used / %s assigned\n",
+					folder.Name, folder.ID, s.describePurge(v.Name, folder),
+					FormatByteSize(w.Usage), FormatByteSize(w.Quota),
+				)
+				continue
+			}
 			_, _ = fmt.Fprintf(
 				writer,
-				"\t%s - %s used / %s assigned\n",
+				"\t%s - %s used / %s assigned",
 				w.Name, FormatByteSize(w.Usage), FormatByteSize(w.Quota),
 			)
+			if !w.Expires.IsZero() {
+				if w.Expires.Sub(now) <= s.ExpiryPolicies[v.Name].warning() {
+					_, _ = fmt.Fprintf(writer, " ⚠ expires after %s", describeExpiry(w.Expires))
+				} else {
+					_, _ = fmt.Fprintf(writer, " (kept until %s)", describeExpiry(w.Expires))
+				}
+			}
+			if threshold := s.softThreshold(w.Usage, w.Quota); threshold > 0 {
+				_, _ = fmt.Fprintf(writer, " ⚠ over %d%% full", threshold)
+			}
+			if f, ok := s.forecastFolder(v.Name, w, now); ok {
+				horizon := cmp.Or(s.ForecastHorizon, DefaultForecastHorizon)
+				if f.TimeToFull >= 0 && f.TimeToFull <= horizon {
+					_, _ = fmt.Fprintf(writer, " ⚠ %s", f.Describe())
+				} else {
+					_, _ = fmt.Fprintf(writer, " (%s)", f.Describe())
+				}
+			}
+			_, _ = fmt.Fprintln(writer)
+		}
+	}
+	jobs := s.jobs.Owned(checkTarget.Username)
+	if len(jobs) > 0 {
+		_, _ = fmt.Fprint(writer, "\nRecent operations:\n")
+	}
+	for _, job := range jobs {
+		_, _ = fmt.Fprintf(writer, "\t%s: %s\n", job.ID, job.Describe())
+	}
+	pending := s.approvals.Requested(checkTarget.Username)
+	if len(pending) > 0 {
+		_, _ = fmt.Fprint(writer, "\nWaiting for approval:\n")
+	}
+	for _, p := range pending {
+		_, _ = fmt.Fprintf(writer, "\t%s: %s\n", p.ID, p.Describe())
+	}
+	grants := s.grants.Active(checkTarget.Username, now)
+	if len(grants) > 0 {
+		_, _ = fmt.Fprint(writer, "\nThe allocations above include the following grants:\n")
+	}
+	for _, grant := range grants {
+		_, _ = fmt.Fprintf(writer, "\t%s\n", grant.Describe())
+	}
+	if len(poolEntries) > 0 {
+		_, _ = fmt.Fprint(writer, "\nThe user is a member of the following pools:\n\n")
+	}
+	for _, v := range poolEntries {
+		_, _ = fmt.Fprintf(
+			writer,
+			"%s (%s) - %s assigned / %s allocated\n",
+			v.Pool, v.Tier, FormatByteSize(v.UsedQuota), FormatByteSize(v.AllowedQuota),
+		)
+		for _, w := range v.MemberEntries {
+			_, _ = fmt.Fprintf(writer, "\t%s - %s assigned\n", w.Name, FormatByteSize(w.Quota))
 		}
 	}
 	if folderOmitted {
@@ -97,3 +175,53 @@ type quotaEntry struct {
 	UsedQuota    int
 	AllowedQuota int
 }
+
+type poolEntry struct {
+	Pool          string
+	Tier          string
+	MemberEntries []Quota
+	UsedQuota     int
+	AllowedQuota  int
+}
+
+// poolEntries returns the usage of every pool the user is a member of, broken down by member.
+func (s *Server) poolEntries(ctx context.Context, checkTarget *user.User) ([]poolEntry, error) {
+	groups, err := groupNames(checkTarget)
+	if err != nil {
+		return nil, err
+	}
+	var result []poolEntry
+	for _, pool := range groups {
+		for tierName, allowed := range s.poolQuota(pool) {
+			if _, ok := s.Tiers[tierName]; !ok {
+				continue
+			}
+			entries, usedQuota, err := s.poolUsed(ctx, tierName, pool)
+			if err != nil {
+				return nil, fmt.Errorf("failed to retrieve usage of pool %s in %s: %w", pool, tierName, err)
+			}
+			memberQuota := make(map[string]int)
+			for _, entry := range entries {
+				memberQuota[entry.DescribeOwner()] += entry.Quota
+			}
+			memberEntries := make([]Quota, 0, len(memberQuota))
+			for member, quota := range memberQuota {
+				memberEntries = append(memberEntries, Quota{Name: member, Quota: quota})
+			}
+			slices.SortFunc(memberEntries, func(a, b Quota) int {
+				return strings.Compare(a.Name, b.Name)
+			})
+			result = append(result, poolEntry{
+				Pool:          pool,
+				Tier:          tierName,
+				MemberEntries: memberEntries,
+				UsedQuota:     usedQuota,
+				AllowedQuota:  allowed,
+			})
+		}
+	}
+	slices.SortFunc(result, func(a, b poolEntry) int {
+		return cmp.Or(strings.Compare(a.Pool, b.Pool), strings.Compare(a.Tier, b.Tier))
+	})
+	return result, nil
+}
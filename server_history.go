@@ -0,0 +1,49 @@
+package storaged
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func (s *Server) handleHistory(writer http.ResponseWriter, req *http.Request) {
+	var historyReq HistoryRequest
+	_, ok := s.readRequest(writer, req, &historyReq)
+	if !ok {
+		return
+	}
+	if _, ok := s.Tiers[historyReq.Tier]; historyReq.Tier != "" && !ok {
+		http.Error(writer, fmt.Sprintf("Invalid tier requested: %q does not exist!", historyReq.Tier), http.StatusBadRequest)
+		return
+	}
+	var match func(sample historySample) bool
+	switch {
+	case historyReq.Folder != "" && historyReq.User != "":
+		http.Error(writer, "Only one of folder or user may be requested.", http.StatusBadRequest)
+		return
+	case historyReq.Folder != "" && historyReq.Tier == "":
+		http.Error(writer, "The tier of the folder is required.", http.StatusBadRequest)
+		return
+	case historyReq.Folder != "":
+		match = func(sample historySample) bool {
+			return sample.Tier == historyReq.Tier && sample.Folder == historyReq.Folder
+		}
+	case historyReq.User != "":
+		match = func(sample historySample) bool {
+			return (historyReq.Tier == "" || sample.Tier == historyReq.Tier) && sample.Owner == historyReq.User
+		}
+	case historyReq.Tier != "":
+		match = func(sample historySample) bool {
+			return sample.Tier == historyReq.Tier
+		}
+	default:
+		http.Error(writer, "One of folder, user or tier is required.", http.StatusBadRequest)
+		return
+	}
+	points := s.history.Series(match)
+	if points == nil {
+		points = []HistoryPoint{}
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(points)
+}
@@ -0,0 +1,108 @@
+package storaged
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/user"
+)
+
+// runJob performs the job according to its kind.
+func (s *Server) runJob(ctx context.Context, job Job, progress func(done, total int)) error {
+	switch job.Kind {
+	case JobKindMigrate:
+		return s.runMigration(ctx, job, progress)
+	case JobKindDelete:
+		return s.runPurge(ctx, job, progress)
+	case JobKindSnapshotRestore:
+		return s.runSnapshotRestore(ctx, job, progress)
+	case JobKindReassign:
+		return s.runReassign(ctx, job, progress)
+	default:
+		return fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+}
+
+func (s *Server) handleJobs(writer http.ResponseWriter, req *http.Request) {
+	var jobReq JobRequest
+	submitter, ok := s.readRequest(writer, req, &jobReq)
+	if !ok {
+		return
+	}
+	if jobReq.ID != "" {
+		s.writeJob(writer, submitter, jobReq.ID)
+		return
+	}
+	jobs := s.jobs.Owned(submitter.Username)
+	if len(jobs) == 0 {
+		_, _ = fmt.Fprintln(writer, "You have no recent operations.")
+		return
+	}
+	for _, job := range jobs {
+		_, _ = fmt.Fprintf(writer, "%s: %s\n", job.ID, job.Describe())
+	}
+}
+
+func (s *Server) handleJob(writer http.ResponseWriter, req *http.Request) {
+	var jobReq struct{}
+	submitter, ok := s.readRequest(writer, req, &jobReq)
+	if !ok {
+		return
+	}
+	s.writeJob(writer, submitter, req.PathValue("id"))
+}
+
+func (s *Server) writeJob(writer http.ResponseWriter, submitter *user.User, id string) {
+	job, status, msg := s.visibleJob(submitter, id)
+	if status != http.StatusOK {
+		http.Error(writer, msg, status)
+		return
+	}
+	_, _ = fmt.Fprintf(writer, "%s: %s\n", job.ID, job.Describe())
+}
+
+func (s *Server) handleCancelJob(writer http.ResponseWriter, req *http.Request) {
+	var jobReq struct{}
+	submitter, ok := s.readRequest(writer, req, &jobReq)
+	if !ok {
+		return
+	}
+	job, status, msg := s.visibleJob(submitter, req.PathValue("id"))
+	if status != http.StatusOK {
+		http.Error(writer, msg, status)
+		return
+	}
+	if job.Kind == JobKindDelete && job.State == JobRunning {
+		http.Error(writer, "Permanent deletions cannot be stopped once they have started.", http.StatusBadRequest)
+		return
+	}
+	err := s.jobs.Cancel(job.ID)
+	switch {
+	case errors.Is(err, ErrJobFinished):
+		http.Error(writer, "The operation has already finished.", http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(writer, "Failed to cancel operation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = fmt.Fprintln(writer, "The operation is being cancelled.")
+}
+
+// visibleJob returns the job if it exists and belongs to the submitter or the submitter is an
+// administrator.
+func (s *Server) visibleJob(submitter *user.User, id string) (job Job, statusCode int, output string) {
+	job, ok := s.jobs.Get(id)
+	if ok && job.Owner != submitter.Username {
+		isAdmin, err := s.isAdmin(submitter)
+		if err != nil {
+			return Job{}, http.StatusInternalServerError, "Failed to check administrator status: " + err.Error()
+		}
+		// Do not reveal that the job exists to other users.
+		ok = isAdmin
+	}
+	if !ok {
+		return Job{}, http.StatusNotFound, "Job " + id + " does not exist or has expired."
+	}
+	return job, http.StatusOK, ""
+}
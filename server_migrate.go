@@ -0,0 +1,261 @@
+package storaged
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os/user"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MetadataMigration is the metadata key marking a folder as part of an ongoing migration. It is
+// "to:<tier>" on the source folder and "from:<tier>" on the destination folder.
+const MetadataMigration = "migration"
+
+func (s *Server) handleMigrateFolder(writer http.ResponseWriter, req *http.Request) {
+	var migrateReq MigrateRequest
+	submitter, ok := s.readRequest(writer, req, &migrateReq)
+	if !ok {
+		return
+	}
+	for _, tier := range []string{migrateReq.Tier, migrateReq.DestinationTier} {
+		if _, ok := s.Tiers[tier]; !ok {
+			http.Error(writer, fmt.Sprintf("Invalid tier requested: %q does not exist!", tier), http.StatusBadRequest)
+			return
+		}
+	}
+	if migrateReq.Tier == migrateReq.DestinationTier {
+		http.Error(writer, "The folder is already in "+migrateReq.Tier+".", http.StatusBadRequest)
+		return
+	}
+	err := ValidateProjectName(migrateReq.Name)
+	if err != nil {
+		http.Error(writer, "Invalid name requested: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, output := s.attemptMigrate(req.Context(), submitter, migrateReq)
+	if status == http.StatusInternalServerError {
+		output += "\n\nTry again later and contact administrators if the folder is in an unexpected state."
+	}
+	writer.WriteHeader(status)
+	_, _ = fmt.Fprintln(writer, output)
+}
+
+// attemptMigrate creates the destination folder and starts copying the data to it in the
+// background.
+func (s *Server) attemptMigrate(
+	ctx context.Context, submitter *user.User, migrateReq MigrateRequest,
+) (statusCode int, output string) {
+	groups, err := groupNames(submitter)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to find groups of user: " + err.Error()
+	}
+	name := migrateReq.Name
+	srcFS := s.Tiers[migrateReq.Tier]
+	dstFS := s.Tiers[migrateReq.DestinationTier]
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+	defer s.refreshFolder(migrateReq.Tier, name)
+	defer s.refreshFolder(migrateReq.DestinationTier, name)
+	currentQuota, err := srcFS.Quota(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusBadRequest, "Folder " + name + " does not exist in " + migrateReq.Tier + "."
+	case err != nil:
+		return http.StatusInternalServerError, "Failed to calculate quota for existing folder: " + err.Error()
+	}
+	currentOwner, err := srcFS.FileOwner(name)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to fetch owner for existing folder: " + err.Error()
+	}
+	if currentOwner != submitter.Username {
+		return http.StatusBadRequest, "The folder to migrate does not belong to you!"
+	}
+	migration, err := srcFS.Metadata(name, MetadataMigration)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check folder migration status: " + err.Error()
+	}
+	if migration != "" {
+		return http.StatusBadRequest, "The folder is already being migrated."
+	}
+	pool, err := srcFS.Metadata(name, MetadataPool)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to fetch pool for existing folder: " + err.Error()
+	}
+	expires, err := folderExpiry(srcFS, name)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to fetch expiry date for existing folder: " + err.Error()
+	}
+	msg := s.checkExpiry(migrateReq.DestinationTier, expires, true, time.Now())
+	if msg != "" {
+		return http.StatusBadRequest, msg + " Change the expiry date of the folder before migrating it."
+	}
+	_, err = dstFS.Quota(name)
+	switch {
+	case err == nil:
+		return http.StatusBadRequest, "Folder " + name + " already exists in " + migrateReq.DestinationTier + "."
+	case !errors.Is(err, fs.ErrNotExist):
+		return http.StatusInternalServerError, "Failed to check destination folder existence: " + err.Error()
+	}
+	if pool != "" && !slices.Contains(groups, pool) {
+		return http.StatusBadRequest, "You are not a member of pool " + pool + "."
+	}
+	tierQuota, quotaUsed, err := s.chargedQuota(ctx, submitter, migrateReq.DestinationTier, pool)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to calculate quota: " + err.Error()
+	}
+	if remainingQuota := tierQuota - quotaUsed; remainingQuota < currentQuota {
+		return http.StatusBadRequest, fmt.Sprintf(
+			"You do not have sufficient quota left in %s to migrate this folder.\n"+
+				"You used %s/%s and have %s left.\n"+
+				"This operation needs %s.",
+			migrateReq.DestinationTier,
+			FormatByteSize(quotaUsed), FormatByteSize(tierQuota), FormatByteSize(remainingQuota),
+			FormatByteSize(currentQuota),
+		)
+	}
+
+	// Create the destination folder with the same quota. It is charged from now on so that the
+	// allocation cannot be taken by something else while the data is being copied.
+	err = dstFS.CreateFolder(name, submitter.Uid, submitter.Gid)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to create destination folder: " + err.Error()
+	}
+	err = errors.Join(
+		dstFS.SetQuota(name, currentQuota),
+		dstFS.SetMetadata(name, MetadataPool, pool),
+		dstFS.SetMetadata(name, MetadataExpires, formatExpiry(expires)),
+		dstFS.SetMetadata(name, MetadataMigration, "from:"+migrateReq.Tier),
+		srcFS.SetMetadata(name, MetadataMigration, "to:"+migrateReq.DestinationTier),
+	)
+	if err != nil {
+		_ = srcFS.SetMetadata(name, MetadataMigration, "")
+		_ = dstFS.RemoveAll(name)
+		return http.StatusInternalServerError, "Failed to set up destination folder: " + err.Error()
+	}
+	job, err := s.jobs.Submit(Job{
+		Kind:            JobKindMigrate,
+		Owner:           submitter.Username,
+		Tier:            migrateReq.Tier,
+		Folder:          name,
+		DestinationTier: migrateReq.DestinationTier,
+		Description:     fmt.Sprintf("Migrating %s from %s to %s", name, migrateReq.Tier, migrateReq.DestinationTier),
+	})
+	if err != nil {
+		_ = srcFS.SetMetadata(name, MetadataMigration, "")
+		_ = dstFS.RemoveAll(name)
+		return http.StatusInternalServerError, "Failed to start migration: " + err.Error()
+	}
+	return http.StatusOK, fmt.Sprintf(
+		"Your folder will be migrated to %s (job %s).\n"+
+			"Avoid modifying it until the migration completes, as changes may not be copied.\n"+
+			"The progress is shown in your quota report.",
+		migrateReq.DestinationTier, job.ID,
+	)
+}
+
+// runMigration copies the data of a folder to the destination tier, points the project link to
+// it and removes the source folder. If the job is cancelled while copying, the destination folder
+// is removed and the source folder is left as it was.
+func (s *Server) runMigration(ctx context.Context, job Job, progress func(done, total int)) error {
+	owner, err := user.Lookup(job.Owner)
+	if err != nil {
+		return fmt.Errorf("error looking up owner: %w", err)
+	}
+	name := job.Folder
+	srcFS, ok := s.Tiers[job.Tier]
+	if !ok {
+		return fmt.Errorf("tier %q no longer exists", job.Tier)
+	}
+	dstFS, ok := s.Tiers[job.DestinationTier]
+	if !ok {
+		return fmt.Errorf("tier %q no longer exists", job.DestinationTier)
+	}
+	// The destination folder is only unmarked once all data has been copied to it, after which the
+	// user may already be writing to it through the project link.
+	migration, err := dstFS.Metadata(name, MetadataMigration)
+	if err != nil {
+		return fmt.Errorf("error checking migration status: %w", err)
+	}
+	switchedOver := migration == ""
+	total, err := srcFS.Usage(name)
+	srcExists := !errors.Is(err, fs.ErrNotExist)
+	if err != nil {
+		total = 0
+	}
+	var copyErr error
+	if srcExists && !switchedOver {
+		progress(0, total)
+		// Start over if an earlier attempt was interrupted part way through copying.
+		copyErr = clearTree(dstFS.PathFor(name))
+		if copyErr == nil {
+			copyErr = copyTree(ctx, srcFS.PathFor(name), dstFS.PathFor(name), func(copied int) {
+				progress(copied, total)
+			})
+		}
+	}
+	// Otherwise an earlier attempt was interrupted after switching over to the destination folder,
+	// and the steps below only need to be repeated.
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+	defer s.refreshFolder(job.Tier, name)
+	defer s.refreshFolder(job.DestinationTier, name)
+	rollback := func(cause error) error {
+		if !srcExists || switchedOver {
+			// The destination folder holds the only complete copy of the data.
+			return cause
+		}
+		// Leave the source folder as it was so that the user can try again.
+		return errors.Join(
+			cause,
+			dstFS.RemoveAll(name),
+			srcFS.SetMetadata(name, MetadataMigration, ""),
+		)
+	}
+	switch {
+	case errors.Is(copyErr, context.Canceled):
+		return rollback(copyErr)
+	case copyErr != nil:
+		return rollback(fmt.Errorf("error copying data: %w", copyErr))
+	}
+	err = dstFS.SetMetadata(name, MetadataMigration, "")
+	if err != nil {
+		return rollback(fmt.Errorf("error marking migration as complete: %w", err))
+	}
+	err = s.ProjectFS.ReplaceLink(name, dstFS.PathFor(name), owner.Uid, owner.Gid)
+	if err != nil {
+		return rollback(fmt.Errorf("error pointing project link to new folder: %w", err))
+	}
+	err = srcFS.RemoveAll(name)
+	if err != nil {
+		return fmt.Errorf("data has been migrated but the old folder could not be removed: %w", err)
+	}
+	s.notify(Event{
+		Kind:   EventFolderMigrated,
+		User:   owner.Username,
+		Tier:   job.DestinationTier,
+		Folder: name,
+		Bytes:  total,
+		Message: fmt.Sprintf(
+			"Your folder %s has been migrated from %s to %s.",
+			name, job.Tier, job.DestinationTier,
+		),
+	})
+	return nil
+}
+
+// describeMigration returns a human-readable message if the folder is part of a migration.
+func describeMigration(migration string) string {
+	switch {
+	case strings.HasPrefix(migration, "to:"):
+		return "The folder is being migrated to " + strings.TrimPrefix(migration, "to:") + "."
+	case strings.HasPrefix(migration, "from:"):
+		return "The folder is being migrated from " + strings.TrimPrefix(migration, "from:") + "."
+	default:
+		return "The folder is being migrated."
+	}
+}
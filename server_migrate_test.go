@@ -0,0 +1,45 @@
+package storaged
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRunMigrationResumeAfterSwitchOver(t *testing.T) {
+	src := newFakeQuotaFS("/hdd")
+	dst := newFakeQuotaFS("/ssd")
+	s, current := testServer(t, src, 1<<40, func(cfg *ServerConfig) {
+		cfg.Tiers["ssd"] = dst
+	})
+	projectFS := s.ProjectFS.(*fakeQuotaFS)
+	// The earlier attempt was interrupted while removing the source folder, after the user had
+	// already written to the destination folder through the project link.
+	src.addFolder("proj", current.Username, 1<<30, 1<<20)
+	err := src.SetMetadata("proj", MetadataMigration, "to:ssd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst.addFolder("proj", current.Username, 1<<30, 1<<25)
+	err = projectFS.CreateLink("proj", dst.PathFor("proj"), current.Uid, current.Gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := Job{Kind: JobKindMigrate, Owner: current.Username, Tier: "hdd", Folder: "proj", DestinationTier: "ssd"}
+	err = s.runMigration(context.Background(), job, func(_, _ int) {
+		t.Error("resumed migration copied data again")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.folder("proj") != nil {
+		t.Error("source folder was not removed")
+	}
+	folder := dst.folder("proj")
+	if folder == nil || folder.usage != 1<<25 {
+		t.Fatalf("destination folder was replaced: %+v", folder)
+	}
+	if link := projectFS.link("proj"); link != dst.PathFor("proj") {
+		t.Errorf("project link points to %q, want %q", link, dst.PathFor("proj"))
+	}
+}
@@ -1,26 +1,108 @@
 package storaged
 
 import (
+	"context"
 	"fmt"
 	"os/user"
+	"slices"
 )
 
 func (s *Server) allowedQuota(checkTarget *user.User) (map[string]int, error) {
-	gids, err := checkTarget.GroupIds()
+	groups, err := groupNames(checkTarget)
 	if err != nil {
-		return nil, fmt.Errorf("error finding group IDs for user %q: %w", checkTarget.Username, err)
+		return nil, err
 	}
+	var allocations []Allocation
+	for _, group := range groups {
+		allocations = append(allocations, s.Allocations[group]...)
+	}
+	quota := combineAllocations(allocations)
+	s.applyGrants(checkTarget.Username, quota)
+	return quota, nil
+}
+
+// isAdmin returns whether the user is a member of any of the administrator groups.
+func (s *Server) isAdmin(u *user.User) (bool, error) {
+	groups, err := groupNames(u)
+	if err != nil {
+		return false, err
+	}
+	for _, group := range groups {
+		if slices.Contains(s.AdminGroups, group) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// chargedQuota returns the quota allocated to and used by whoever a folder in the tier is charged
+// to: the pool if it is not empty, or the user otherwise.
+func (s *Server) chargedQuota(
+	ctx context.Context, u *user.User, tierName, pool string,
+) (allocated, used int, err error) {
+	if pool != "" {
+		_, used, err = s.poolUsed(ctx, tierName, pool)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error calculating quota used by pool: %w", err)
+		}
+		return s.poolQuota(pool)[tierName], used, nil
+	}
+	allQuota, err := s.allowedQuota(u)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error calculating quota allocated to user: %w", err)
+	}
+	_, used, err = s.quotaUsed(ctx, tierName, u.Username)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error calculating quota used by user: %w", err)
+	}
+	return allQuota[tierName], used, nil
+}
+
+// poolQuota returns the quota shared by the members of the pool for each tier.
+func (s *Server) poolQuota(pool string) map[string]int {
+	return combineAllocations(s.Pools[pool])
+}
+
+// combineAllocations returns the quota for each tier granted by the allocations according to
+// their policies.
+func combineAllocations(allocations []Allocation) map[string]int {
 	bestQuota := make(map[string]int)
+	extraQuota := make(map[string]int)
+	overrideQuota := make(map[string]int)
+	for _, v := range allocations {
+		switch v.Policy {
+		case AllocationPolicySum:
+			extraQuota[v.Tier] += v.MaxBytes
+		case AllocationPolicyOverride:
+			overrideQuota[v.Tier] = max(overrideQuota[v.Tier], v.MaxBytes)
+		default:
+			bestQuota[v.Tier] = max(bestQuota[v.Tier], v.MaxBytes)
+		}
+	}
+	for tier, extra := range extraQuota {
+		bestQuota[tier] += extra
+	}
+	for tier, override := range overrideQuota {
+		bestQuota[tier] = override
+	}
+	return bestQuota
+}
+
+// groupNames returns the names of all groups the user is a member of.
+func groupNames(u *user.User) ([]string, error) {
+	gids, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("error finding group IDs for user %q: %w", u.Username, err)
+	}
+	names := make([]string, 0, len(gids))
 	for _, gid := range gids {
 		group, err := user.LookupGroupId(gid)
 		if err != nil {
 			return nil, fmt.Errorf("error finding name of group ID %q: %w", gid, err)
 		}
-		for _, v := range s.Allocations[group.Name] {
-			bestQuota[v.Tier] = max(bestQuota[v.Tier], v.MaxBytes)
-		}
+		names = append(names, group.Name)
 	}
-	return bestQuota, nil
+	return names, nil
 }
 
 func FormatByteSize(byteCount int) string {
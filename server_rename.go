@@ -0,0 +1,173 @@
+package storaged
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os/user"
+	"strings"
+)
+
+func (s *Server) handleRenameFolder(writer http.ResponseWriter, req *http.Request) {
+	var renameReq RenameRequest
+	submitter, ok := s.readRequest(writer, req, &renameReq)
+	if !ok {
+		return
+	}
+	if _, ok := s.Tiers[renameReq.Tier]; !ok {
+		http.Error(writer, fmt.Sprintf("Invalid tier requested: %q does not exist!", renameReq.Tier), http.StatusBadRequest)
+		return
+	}
+	err := ValidateProjectName(renameReq.Name)
+	if err != nil {
+		http.Error(writer, "Invalid name requested: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = ValidateProjectName(renameReq.NewName)
+	if err != nil {
+		http.Error(writer, "Invalid new name requested: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if renameReq.Name == renameReq.NewName {
+		http.Error(writer, "The new name is the same as the current name.", http.StatusBadRequest)
+		return
+	}
+	status, output := s.attemptRename(submitter, renameReq)
+	if status == http.StatusInternalServerError {
+		output += "\n\nTry again later and contact administrators if the folder is in an unexpected state."
+	}
+	writer.WriteHeader(status)
+	_, _ = fmt.Fprintln(writer, output)
+}
+
+func (s *Server) attemptRename(submitter *user.User, renameReq RenameRequest) (statusCode int, output string) {
+	name, newName := renameReq.Name, renameReq.NewName
+	quotaFS := s.Tiers[renameReq.Tier]
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+	defer s.refreshFolder(renameReq.Tier, name)
+	defer s.refreshFolder(renameReq.Tier, newName)
+	owner, err := quotaFS.FileOwner(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusBadRequest, "Folder " + name + " does not exist in " + renameReq.Tier + "."
+	case err != nil:
+		return http.StatusInternalServerError, "Failed to fetch owner for existing folder: " + err.Error()
+	}
+	if owner != submitter.Username {
+		return http.StatusBadRequest, "The folder to rename does not belong to you!"
+	}
+	migration, err := quotaFS.Metadata(name, MetadataMigration)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check folder migration status: " + err.Error()
+	}
+	if migration != "" {
+		return http.StatusBadRequest, describeMigration(migration) + " Try again once it completes."
+	}
+	if s.jobs.Active(renameReq.Tier, name) {
+		// Jobs refer to the folder by name and would fail once it has been renamed.
+		return http.StatusBadRequest, "Another operation on the folder is in progress. Try again once it has completed."
+	}
+	for _, pending := range s.approvals.List() {
+		if pending.Request.Tier == renameReq.Tier && pending.Request.Name == name {
+			return http.StatusBadRequest, fmt.Sprintf(
+				"Request %s for the folder is waiting for approval. "+
+					"Withdraw it or wait for it to be decided before renaming the folder.",
+				pending.ID,
+			)
+		}
+	}
+	taken, err := s.nameTaken(newName)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check folder existence: " + err.Error()
+	}
+	if taken {
+		return http.StatusBadRequest, "Folder " + newName + " already exists."
+	}
+	var groupName, newGroupName string
+	if s.IPA != nil {
+		groupName, newGroupName = s.IPA.GroupPrefix+name, s.IPA.GroupPrefix+newName
+		_, err := user.LookupGroup(groupName)
+		switch {
+		case errors.As(err, new(user.UnknownGroupError)):
+			// The project has no group to rename.
+			groupName = ""
+		case err != nil:
+			return http.StatusInternalServerError, "Failed to look up project group: " + err.Error()
+		default:
+			_, err := user.LookupGroup(newGroupName)
+			if err == nil {
+				return http.StatusBadRequest, "Group " + newGroupName + " already exists."
+			}
+		}
+	}
+
+	// We have validated that the operation is valid. Do it now.
+	err = quotaFS.RenameFolder(name, newName)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to rename folder: " + err.Error()
+	}
+	err = s.ProjectFS.CreateLink(newName, quotaFS.PathFor(newName), submitter.Uid, submitter.Gid)
+	if err != nil {
+		_ = quotaFS.RenameFolder(newName, name)
+		return http.StatusInternalServerError, "Failed to create symlink for folder: " + err.Error()
+	}
+	err = s.ProjectFS.DeleteLink(name)
+	if err != nil {
+		undoErr := errors.Join(s.ProjectFS.DeleteLink(newName), quotaFS.RenameFolder(newName, name))
+		if undoErr != nil {
+			log.Printf("failed to undo rename of %s in %s: %v", name, renameReq.Tier, undoErr)
+			return http.StatusInternalServerError, fmt.Sprintf(
+				"Failed to remove the old link of your folder: %s\nThe rename could not be undone: %s", err, undoErr,
+			)
+		}
+		return http.StatusInternalServerError, "Failed to remove the old link of your folder: " + err.Error()
+	}
+	// The folder has been renamed already and its history and thresholds are not worth failing the
+	// request for.
+	err = s.history.Rename(renameReq.Tier, name, newName)
+	if err != nil {
+		log.Printf("failed to rename usage history of %s in %s: %v", name, renameReq.Tier, err)
+	}
+	err = s.thresholds.Rename(renameReq.Tier, name, newName)
+	if err != nil {
+		log.Printf("failed to rename soft threshold of %s in %s: %v", name, renameReq.Tier, err)
+	}
+	if groupName != "" {
+		err = s.IPA.GroupRename(groupName, newGroupName)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Sprintf(
+				"Your folder has been renamed but group %s could not be renamed: %s", groupName, err,
+			)
+		}
+		return http.StatusOK, fmt.Sprintf(
+			"Your folder has been renamed to %s.\nGroup %s has been renamed to %s.",
+			newName, groupName, newGroupName,
+		)
+	}
+	return http.StatusOK, "Your folder has been renamed to " + newName + "."
+}
+
+// nameTaken reports whether the folder name is used in any tier or in the project FS.
+func (s *Server) nameTaken(name string) (bool, error) {
+	for tierName, quotaFS := range s.Tiers {
+		_, err := quotaFS.Quota(name)
+		switch {
+		case err == nil:
+			return true, nil
+		case !errors.Is(err, fs.ErrNotExist):
+			return false, fmt.Errorf("error checking %s: %w", tierName, err)
+		}
+	}
+	_, err := fs.Stat(s.ProjectFS, name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	case err == nil, strings.Contains(err.Error(), "path escapes"):
+		return true, nil
+	default:
+		return false, fmt.Errorf("error checking project folder: %w", err)
+	}
+}
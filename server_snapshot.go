@@ -0,0 +1,247 @@
+package storaged
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobKindSnapshotRestore copies data out of a snapshot back into its folder.
+const JobKindSnapshotRestore = "snapshot_restore"
+
+// snapshotRestorePrefix is prepended to the snapshot name to form the directory inside the folder
+// that a snapshot is restored into.
+const snapshotRestorePrefix = "restored-"
+
+func (s *Server) handleSnapshots(writer http.ResponseWriter, req *http.Request) {
+	var snapshotReq SnapshotRequest
+	submitter, ok := s.readRequest(writer, req, &snapshotReq)
+	if !ok {
+		return
+	}
+	if _, ok := s.Tiers[snapshotReq.Tier]; !ok {
+		http.Error(writer, fmt.Sprintf("Invalid tier requested: %q does not exist!", snapshotReq.Tier), http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := s.snapshotTier(snapshotReq.Tier); !ok {
+		http.Error(writer, "Snapshots are not enabled in "+snapshotReq.Tier+".", http.StatusBadRequest)
+		return
+	}
+	err := ValidateProjectName(snapshotReq.Name)
+	if err != nil {
+		http.Error(writer, "Invalid name requested: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch snapshotReq.Action {
+	case "list", "create":
+	case "delete", "restore":
+		if _, ok := ParseSnapshotName(snapshotReq.Snapshot); !ok {
+			http.Error(writer, fmt.Sprintf("Invalid snapshot requested: %q", snapshotReq.Snapshot), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(writer, fmt.Sprintf("Invalid action requested: %q", snapshotReq.Action), http.StatusBadRequest)
+		return
+	}
+	if snapshotReq.Path != "" && (snapshotReq.Action != "restore" || !fs.ValidPath(snapshotReq.Path)) {
+		http.Error(writer, fmt.Sprintf("Invalid path requested: %q", snapshotReq.Path), http.StatusBadRequest)
+		return
+	}
+	status, output := s.attemptSnapshot(submitter, snapshotReq)
+	if status == http.StatusInternalServerError {
+		output += "\n\nTry again later and contact administrators if the folder is in an unexpected state."
+	}
+	writer.WriteHeader(status)
+	_, _ = fmt.Fprintln(writer, output)
+}
+
+func (s *Server) attemptSnapshot(submitter *user.User, snapshotReq SnapshotRequest) (statusCode int, output string) {
+	name := snapshotReq.Name
+	quotaFS := s.Tiers[snapshotReq.Tier]
+	snapFS, policy, _ := s.snapshotTier(snapshotReq.Tier)
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+	owner, err := quotaFS.FileOwner(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusBadRequest, "Folder " + name + " does not exist in " + snapshotReq.Tier + "."
+	case err != nil:
+		return http.StatusInternalServerError, "Failed to fetch owner for folder: " + err.Error()
+	}
+	if owner != submitter.Username {
+		isAdmin, err := s.isAdmin(submitter)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to check administrator status: " + err.Error()
+		}
+		// Administrators may look at the snapshots of any folder, but only the owner may change them.
+		if !isAdmin || snapshotReq.Action != "list" {
+			return http.StatusBadRequest, "The folder does not belong to you!"
+		}
+	}
+	snapshots, err := snapFS.Snapshots(name)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to list snapshots: " + err.Error()
+	}
+
+	switch snapshotReq.Action {
+	case "list":
+		return http.StatusOK, describeSnapshots(name, snapshots, policy)
+	case "create":
+		manual := 0
+		for _, snapshot := range snapshots {
+			if !snapshot.Scheduled {
+				manual++
+			}
+		}
+		if manual >= policy.maxManual() {
+			return http.StatusBadRequest, fmt.Sprintf(
+				"You already have %d snapshots of this folder, delete one before taking another.",
+				manual,
+			)
+		}
+		snapshot := NewSnapshot(time.Now(), false)
+		err := snapFS.CreateSnapshot(name, snapshot)
+		switch {
+		case errors.Is(err, fs.ErrExist):
+			return http.StatusBadRequest, "A snapshot was just taken, try again in a moment."
+		case err != nil:
+			return http.StatusInternalServerError, "Failed to take snapshot: " + err.Error()
+		}
+		return http.StatusOK, "Snapshot " + snapshot.Name + " of " + name + " has been taken."
+	}
+
+	i := slices.IndexFunc(snapshots, func(snapshot Snapshot) bool {
+		return snapshot.Name == snapshotReq.Snapshot
+	})
+	if i < 0 {
+		return http.StatusBadRequest, "Snapshot " + snapshotReq.Snapshot + " of " + name + " does not exist."
+	}
+	if snapshotReq.Action == "delete" {
+		if snapshots[i].Scheduled {
+			return http.StatusBadRequest, "Scheduled snapshots are deleted automatically and cannot be deleted by hand."
+		}
+		err := snapFS.DeleteSnapshot(name, snapshotReq.Snapshot)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to delete snapshot: " + err.Error()
+		}
+		return http.StatusOK, "Snapshot " + snapshotReq.Snapshot + " of " + name + " has been deleted."
+	}
+
+	migration, err := quotaFS.Metadata(name, MetadataMigration)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check folder migration status: " + err.Error()
+	}
+	if migration != "" {
+		return http.StatusBadRequest, describeMigration(migration) + " Try again once it has completed."
+	}
+	if s.jobs.Active(snapshotReq.Tier, name) {
+		return http.StatusBadRequest, "Another operation on the folder is in progress. Try again once it has completed."
+	}
+	restoreDir := snapshotRestorePrefix + snapshotReq.Snapshot
+	_, err = os.Lstat(filepath.Join(quotaFS.PathFor(name), restoreDir))
+	switch {
+	case err == nil:
+		return http.StatusBadRequest, fmt.Sprintf(
+			"%s already exists in your folder, move or remove it before restoring again.", restoreDir,
+		)
+	case !errors.Is(err, fs.ErrNotExist):
+		return http.StatusInternalServerError, "Failed to check restore destination: " + err.Error()
+	}
+	restorePath := snapshotReq.Path
+	if restorePath == "" {
+		restorePath = "."
+	}
+	job, err := s.jobs.Submit(Job{
+		Kind:        JobKindSnapshotRestore,
+		Owner:       submitter.Username,
+		Tier:        snapshotReq.Tier,
+		Folder:      name,
+		Snapshot:    snapshotReq.Snapshot,
+		Path:        restorePath,
+		Description: fmt.Sprintf("Restoring %s of %s from %s", restorePath, name, snapshotReq.Snapshot),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to start restore: " + err.Error()
+	}
+	return http.StatusOK, fmt.Sprintf(
+		"%s of snapshot %s will be copied into %s inside your folder (job %s).\n"+
+			"The copy counts towards the quota of the folder.\n"+
+			"The progress is shown in your quota report.",
+		restorePath, snapshotReq.Snapshot, restoreDir, job.ID,
+	)
+}
+
+// runSnapshotRestore copies the requested path out of a snapshot into a new directory inside the
+// folder. The partial copy is removed if the job fails or is cancelled.
+func (s *Server) runSnapshotRestore(ctx context.Context, job Job, progress func(done, total int)) error {
+	owner, err := user.Lookup(job.Owner)
+	if err != nil {
+		return fmt.Errorf("error looking up owner: %w", err)
+	}
+	uid, err := strconv.Atoi(owner.Uid)
+	if err != nil {
+		return fmt.Errorf("error parsing uid of owner: %w", err)
+	}
+	gid, err := strconv.Atoi(owner.Gid)
+	if err != nil {
+		return fmt.Errorf("error parsing gid of owner: %w", err)
+	}
+	snapFS, _, ok := s.snapshotTier(job.Tier)
+	if !ok {
+		return fmt.Errorf("snapshots are no longer enabled in %s", job.Tier)
+	}
+	if ctx.Err() != nil {
+		// Cancelled before anything was copied.
+		return ctx.Err()
+	}
+	defer s.refreshFolder(job.Tier, job.Folder)
+	folderPath := s.Tiers[job.Tier].PathFor(job.Folder)
+	restoreDir := snapshotRestorePrefix + job.Snapshot
+	// Start over if an earlier attempt was interrupted part way through copying.
+	err = os.RemoveAll(filepath.Join(folderPath, restoreDir))
+	if err != nil {
+		return fmt.Errorf("error removing earlier partial restore: %w", err)
+	}
+	progress(0, 0)
+	err = copyInto(
+		ctx, snapFS.SnapshotPath(job.Folder, job.Snapshot), job.Path, folderPath, restoreDir, uid, gid,
+		func(copied int) { progress(copied, 0) },
+	)
+	if err != nil {
+		return errors.Join(err, os.RemoveAll(filepath.Join(folderPath, restoreDir)))
+	}
+	return nil
+}
+
+// describeSnapshots returns a human-readable list of the snapshots of a folder.
+func describeSnapshots(name string, snapshots []Snapshot, policy SnapshotPolicy) string {
+	var b strings.Builder
+	if len(snapshots) == 0 {
+		_, _ = fmt.Fprintf(&b, "%s has no snapshots.", name)
+	} else {
+		_, _ = fmt.Fprintf(&b, "Snapshots of %s:", name)
+	}
+	for _, snapshot := range snapshots {
+		kind := "taken by you"
+		if snapshot.Scheduled {
+			kind = "scheduled"
+		}
+		_, _ = fmt.Fprintf(&b, "\n\t%s (%s, %s)", snapshot.Name, snapshot.Created.Local().Format(time.DateTime), kind)
+	}
+	if policy.Interval > 0 {
+		_, _ = fmt.Fprintf(
+			&b, "\nA snapshot is taken every %s and the latest %d are kept.", policy.Interval, policy.keep(),
+		)
+	}
+	_, _ = fmt.Fprintf(&b, "\nYou may take up to %d snapshots of your own.", policy.maxManual())
+	return b.String()
+}
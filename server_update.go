@@ -1,12 +1,15 @@
 package storaged
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
 	"os/user"
+	"slices"
 	"strings"
+	"time"
 )
 
 func (s *Server) handleUpdateFolder(writer http.ResponseWriter, req *http.Request) {
@@ -15,48 +18,145 @@ func (s *Server) handleUpdateFolder(writer http.ResponseWriter, req *http.Reques
 	if !ok {
 		return
 	}
-	if _, ok := s.Tiers[updateReq.Tier]; !ok {
+	if msg := s.validateUpdate(updateReq); msg != "" {
 		writer.WriteHeader(http.StatusBadRequest)
-		_, _ = fmt.Fprintf(writer, "Invalid tier requested: %q does not exist!\n", updateReq.Tier)
-		_, _ = fmt.Fprintln(writer, "Check your allocated quota first.")
+		_, _ = fmt.Fprintln(writer, msg)
 		return
 	}
+	status, output := s.attemptAssign(req.Context(), submitter, updateReq, false)
+	if status == http.StatusInternalServerError {
+		output += "\n\nTry again later and contact administrators if the folder is in an unexpected state."
+	}
+	writer.WriteHeader(status)
+	_, _ = fmt.Fprintln(writer, output)
+}
+
+// validateUpdate returns a message explaining why the update is invalid regardless of the state of
+// the folder, or the empty string if it is not.
+func (s *Server) validateUpdate(updateReq UpdateRequest) string {
+	if _, ok := s.Tiers[updateReq.Tier]; !ok {
+		return fmt.Sprintf("Invalid tier requested: %q does not exist!\nCheck your allocated quota first.", updateReq.Tier)
+	}
+	if _, ok := s.Pools[updateReq.Pool]; updateReq.Pool != "" && !ok {
+		return fmt.Sprintf("Invalid pool requested: %q does not exist!", updateReq.Pool)
+	}
 	err := ValidateProjectName(updateReq.Name)
 	if err != nil {
-		writer.WriteHeader(http.StatusBadRequest)
-		_, _ = fmt.Fprintf(writer, "Invalid name requested: %s\n", err)
-		return
+		return "Invalid name requested: " + err.Error()
 	}
 	sizeInBytes := updateReq.SizeInGB * 1000 * 1000 * 1000
 	if sizeInBytes < 0 || sizeInBytes < updateReq.SizeInGB {
-		writer.WriteHeader(http.StatusBadRequest)
-		_, _ = fmt.Fprintln(writer, "Provided folder size is invalid.")
-		return
+		return "Provided folder size is invalid."
 	}
-	status, output := s.attemptAssign(submitter, updateReq)
-	if status == http.StatusInternalServerError {
-		output += "\n\nTry again later and contact administrators if the folder is in an unexpected state."
+	if updateReq.Permanent && sizeInBytes != 0 {
+		return "Only deletions can be permanent."
 	}
-	writer.WriteHeader(status)
-	_, _ = fmt.Fprintln(writer, output)
+	if updateReq.Permanent && updateReq.Confirm != updateReq.Name {
+		return "Permanently deleting a folder cannot be undone.\nConfirm by repeating the folder name."
+	}
+	if updateReq.ExpiresOn != "" && sizeInBytes == 0 {
+		return "Deleted folders cannot have an expiry date."
+	}
+	if updateReq.ExpiresOn != "" {
+		_, err := parseExpiresOn(updateReq.ExpiresOn, time.Now())
+		if err != nil {
+			return "Provided " + err.Error() + "."
+		}
+	}
+	return ""
+}
+
+// folderUpdate is an UpdateRequest that has been checked against the current state of its folder.
+type folderUpdate struct {
+	UpdateRequest
+	// pool is the pool the folder is charged to, which may differ from the requested pool for
+	// existing folders.
+	pool           string
+	expires        time.Time
+	currentQuota   int
+	quotaRequested int
 }
 
-func (s *Server) attemptAssign(submitter *user.User, updateReq UpdateRequest) (statusCode int, output string) {
+// chargeChange returns how much the quota charged to the allocation of the folder changes once
+// the update is applied.
+func (u folderUpdate) chargeChange() int {
+	switch {
+	case u.quotaRequested == 0 && u.Permanent:
+		return -u.currentQuota
+	case u.quotaRequested == 0:
+		// Deleted folders stay charged while they are in the trash.
+		return 0
+	default:
+		return u.quotaRequested - u.currentQuota
+	}
+}
+
+// attemptAssign applies the update for the submitter. Large folders are stored as pending approval
+// unless approved is set.
+func (s *Server) attemptAssign(
+	ctx context.Context, submitter *user.User, updateReq UpdateRequest, approved bool,
+) (statusCode int, output string) {
 	// Check allowed quota.
-	allQuota, err := s.allowedQuota(submitter)
+	groups, err := groupNames(submitter)
 	if err != nil {
-		return http.StatusInternalServerError, "Failed to calculate quota allocated to user: " + err.Error()
+		return http.StatusInternalServerError, "Failed to find groups of user: " + err.Error()
 	}
-	tierQuota := allQuota[updateReq.Tier]
-	quotaFS := s.Tiers[updateReq.Tier]
 	s.updateMutex.Lock()
 	defer s.updateMutex.Unlock()
-	_, quotaUsed, err := QuotaUsed(quotaFS, submitter.Username)
+	defer s.refreshFolder(updateReq.Tier, updateReq.Name)
+	update, status, output := s.planUpdate(submitter, groups, updateReq, time.Now())
+	if status != 0 {
+		return status, output
+	}
+	tierQuota, quotaUsed, err := s.chargedQuota(ctx, submitter, updateReq.Tier, update.pool)
 	if err != nil {
-		return http.StatusInternalServerError, "Failed to calculate quota used by user: " + err.Error()
+		return http.StatusInternalServerError, "Failed to calculate quota: " + err.Error()
 	}
 	remainingQuota := tierQuota - quotaUsed
-	currentQuota, err := quotaFS.Quota(updateReq.Name)
+	var approvers []string
+	if quotaNeeded := update.chargeChange(); quotaNeeded > 0 {
+		if remainingQuota < quotaNeeded {
+			return http.StatusBadRequest, fmt.Sprintf(
+				"You do not have sufficient quota left to assign to this tier.\n"+
+					"You used %s/%s and have %s left.\n"+
+					"This operation needs %s.",
+				FormatByteSize(quotaUsed), FormatByteSize(tierQuota), FormatByteSize(remainingQuota),
+				FormatByteSize(update.quotaRequested),
+			)
+		}
+		if !approved {
+			approvers = s.requiredApprovers(groups, updateReq.Tier, update.pool, update.quotaRequested)
+			if len(approvers) > 0 && !updateReq.DryRun {
+				return s.requestApproval(submitter, updateReq, approvers)
+			}
+		}
+	}
+	if updateReq.DryRun {
+		return http.StatusOK, s.describeDryRun(update, tierQuota, quotaUsed, approvers)
+	}
+	return s.applyUpdate(submitter, update)
+}
+
+// planUpdate checks the update against the current state of its folder. It returns a status code
+// of zero if the update should be applied, or the response to send otherwise. The caller must hold
+// updateMutex.
+func (s *Server) planUpdate(
+	submitter *user.User, groups []string, updateReq UpdateRequest, now time.Time,
+) (update folderUpdate, statusCode int, output string) {
+	quotaFS := s.Tiers[updateReq.Tier]
+	update = folderUpdate{
+		UpdateRequest:  updateReq,
+		pool:           updateReq.Pool,
+		quotaRequested: updateReq.SizeInGB * 1000 * 1000 * 1000,
+	}
+	var err error
+	if updateReq.ExpiresOn != "" {
+		update.expires, err = parseExpiresOn(updateReq.ExpiresOn, now)
+		if err != nil {
+			return update, http.StatusBadRequest, "Provided " + err.Error() + "."
+		}
+	}
+	update.currentQuota, err = quotaFS.Quota(updateReq.Name)
 	switch {
 	case errors.Is(err, fs.ErrNotExist):
 		// Check that the folder does not exist in the main project FS.
@@ -66,51 +166,70 @@ func (s *Server) attemptAssign(submitter *user.User, updateReq UpdateRequest) (s
 			// This is fine. We will create the folder below.
 			break
 		case err == nil, strings.Contains(err.Error(), "path escapes"):
-			return http.StatusBadRequest, "Folder " + updateReq.Name + " already exists in another tier."
+			return update, http.StatusBadRequest, "Folder " + updateReq.Name + " already exists in another tier."
 		default:
-			return http.StatusInternalServerError, "Failed to check project folder existence: " + err.Error()
+			return update, http.StatusInternalServerError, "Failed to check project folder existence: " + err.Error()
 		}
-		currentQuota = 0
+		update.currentQuota = 0
 	case err != nil:
-		return http.StatusInternalServerError, "Failed to calculate quota for existing folder: " + err.Error()
+		return update, http.StatusInternalServerError, "Failed to calculate quota for existing folder: " + err.Error()
 	default:
 		// Do a check that the folder actually belongs to us.
 		currentOwner, err := quotaFS.FileOwner(updateReq.Name)
 		if err != nil {
-			return http.StatusInternalServerError, "Failed to fetch owner for existing folder: " + err.Error()
+			return update, http.StatusInternalServerError, "Failed to fetch owner for existing folder: " + err.Error()
 		}
 		if currentOwner != submitter.Username {
-			return http.StatusBadRequest, "The folder to update does not belong to you!"
+			return update, http.StatusBadRequest, "The folder to update does not belong to you!"
+		}
+		migration, err := quotaFS.Metadata(updateReq.Name, MetadataMigration)
+		if err != nil {
+			return update, http.StatusInternalServerError, "Failed to check folder migration status: " + err.Error()
+		}
+		if migration != "" {
+			return update, http.StatusBadRequest, describeMigration(migration) + " Try again once it completes."
+		}
+		// Existing folders stay charged to whichever pool they were created in.
+		currentPool, err := quotaFS.Metadata(updateReq.Name, MetadataPool)
+		if err != nil {
+			return update, http.StatusInternalServerError, "Failed to fetch pool for existing folder: " + err.Error()
+		}
+		if update.pool != "" && update.pool != currentPool {
+			return update, http.StatusBadRequest, "The folder to update is not charged to pool " + update.pool + "."
+		}
+		update.pool = currentPool
+	}
+	currentQuota, quotaRequested := update.currentQuota, update.quotaRequested
+	if quotaRequested != 0 {
+		msg := s.checkExpiry(updateReq.Tier, update.expires, currentQuota == 0, now)
+		if msg != "" {
+			return update, http.StatusBadRequest, msg
 		}
 	}
-	quotaRequested := updateReq.SizeInGB * 1000 * 1000 * 1000
 	// Three cases: We are growing storage, shrinking it or doing nothing.
 	switch {
 	case currentQuota == 0 && quotaRequested == 0:
-		return http.StatusOK, "Folder already does not exist."
-	case currentQuota == quotaRequested:
+		return update, http.StatusOK, "Folder already does not exist."
+	case currentQuota == quotaRequested && update.expires.IsZero():
 		// Doing nothing.
-		return http.StatusOK, "Quota is unchanged."
+		return update, http.StatusOK, "Quota is unchanged."
+	case currentQuota == quotaRequested:
+		// Only changing the expiry date.
 	case currentQuota < quotaRequested:
-		// Growing storage.
-		quotaNeeded := quotaRequested - currentQuota
-		if remainingQuota < quotaNeeded {
-			return http.StatusBadRequest, fmt.Sprintf(
-				"You do not have sufficient quota left to assign to this tier.\n"+
-					"You used %s/%s and have %s left.\n"+
-					"This operation needs %s.",
-				FormatByteSize(quotaUsed), FormatByteSize(tierQuota), FormatByteSize(remainingQuota),
-				FormatByteSize(quotaRequested),
-			)
+		// Growing storage. Whether there is enough allocation left is up to the caller.
+		if update.pool != "" && !slices.Contains(groups, update.pool) {
+			return update, http.StatusBadRequest, "You are not a member of pool " + update.pool + "."
 		}
+	case quotaRequested == 0:
+		// Deleting the folder. It is moved to the trash, so it does not need to be empty.
 	default:
 		// Shrinking storage.
 		currentUsage, err := quotaFS.Usage(updateReq.Name)
 		if err != nil {
-			return http.StatusInternalServerError, "Failed to calculate usage for existing folder: " + err.Error()
+			return update, http.StatusInternalServerError, "Failed to calculate usage for existing folder: " + err.Error()
 		}
 		if currentUsage > quotaRequested {
-			return http.StatusBadRequest, fmt.Sprintf(
+			return update, http.StatusBadRequest, fmt.Sprintf(
 				"You are currently using more storage than the quota you requested.\n"+
 					"You are currently using %s.\n"+
 					"Please delete some files before requesting to shrink the folder quota.",
@@ -118,57 +237,223 @@ func (s *Server) attemptAssign(submitter *user.User, updateReq UpdateRequest) (s
 			)
 		}
 	}
-	// We have validated that the operation is valid. Do it now.
-	if quotaRequested == 0 {
-		// Delete the folder. We have established ownership above.
-		err := quotaFS.DeleteFolder(updateReq.Name)
-		switch {
-		case err != nil && strings.Contains(err.Error(), "directory not empty"):
-			return http.StatusBadRequest, "Your directory is not empty."
-		case err != nil:
-			return http.StatusInternalServerError, fmt.Sprintf(
-				"Failed to delete folder: %s", err,
-			)
+	return update, 0, ""
+}
+
+// applyUpdate applies an update returned by planUpdate. The caller must hold updateMutex.
+func (s *Server) applyUpdate(submitter *user.User, update folderUpdate) (statusCode int, output string) {
+	if update.currentQuota == update.quotaRequested {
+		err := s.setFolderExpiry(update)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to change expiry date: " + err.Error()
 		}
-		err = s.ProjectFS.DeleteLink(updateReq.Name)
+		return http.StatusOK, "Your folder is now kept until " + describeExpiry(update.expires) + "."
+	}
+	if update.quotaRequested == 0 {
+		// Delete the folder. We have established ownership above.
+		trashed, purgeAt, err := s.trashFolder(update)
 		if err != nil {
-			return http.StatusInternalServerError, fmt.Sprintf(
-				"Failed to delete link: %s", err,
+			return http.StatusInternalServerError, "Failed to delete folder: " + err.Error()
+		}
+		if update.Permanent {
+			job, err := s.startPurge(submitter, update.Tier, trashed)
+			if err != nil {
+				return http.StatusInternalServerError, fmt.Sprintf(
+					"Your folder has been moved to the trash but could not be deleted permanently: %s", err,
+				)
+			}
+			s.notifyUpdate(submitter, update, purgeAt)
+			return http.StatusOK, fmt.Sprintf(
+				"Your project folder is being deleted permanently (job %s).\n"+
+					"The progress is shown in your quota report.",
+				job.ID,
 			)
 		}
-		return http.StatusOK, "Your project folder has been deleted."
+		s.notifyUpdate(submitter, update, purgeAt)
+		return http.StatusOK, "Your project folder has been moved to the trash.\n" + s.restoreNote(purgeAt)
+	}
+	err := s.setFolderQuota(submitter, update)
+	if err != nil && update.currentQuota == 0 {
+		return http.StatusInternalServerError, "Failed to create folder: " + err.Error()
+	}
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to update quota of folder: " + err.Error()
+	}
+	s.notifyUpdate(submitter, update, time.Time{})
+	if update.currentQuota != 0 {
+		return http.StatusOK, "Your folder's quota has been updated."
+	}
+	output = fmt.Sprintf(
+		"Your folder has been created.\n"+
+			"You can access it at %s.\n",
+		s.ProjectFS.PathFor(update.Name),
+	)
+	if !update.expires.IsZero() {
+		output += fmt.Sprintf("It is kept until %s and moved to the trash afterwards.\n", describeExpiry(update.expires))
 	}
-	if currentQuota == 0 {
+	return http.StatusOK, output
+}
+
+// setFolderExpiry changes only the expiry date of the folder.
+func (s *Server) setFolderExpiry(update folderUpdate) error {
+	return s.Tiers[update.Tier].SetMetadata(update.Name, MetadataExpires, formatExpiry(update.expires))
+}
+
+// trashFolder moves the folder to the trash and deletes its link.
+func (s *Server) trashFolder(update folderUpdate) (trashedFolder, time.Time, error) {
+	trashed, purgeAt, err := s.moveToTrash(update.Tier, update.Name)
+	if err != nil {
+		return trashedFolder{}, time.Time{}, err
+	}
+	err = s.ProjectFS.DeleteLink(update.Name)
+	if err != nil {
+		return trashed, purgeAt, fmt.Errorf("error deleting link: %w", err)
+	}
+	return trashed, purgeAt, nil
+}
+
+// setFolderQuota creates the folder if it does not exist yet and sets its quota and expiry date.
+func (s *Server) setFolderQuota(submitter *user.User, update folderUpdate) error {
+	quotaFS := s.Tiers[update.Tier]
+	if update.currentQuota == 0 {
 		// If the folder did not exist previously, create it.
-		err := quotaFS.CreateFolder(updateReq.Name, submitter.Uid, submitter.Gid)
+		err := quotaFS.CreateFolder(update.Name, submitter.Uid, submitter.Gid)
 		if err != nil {
-			return http.StatusInternalServerError, fmt.Sprintf(
-				"Failed to create folder: %s", err,
-			)
+			return fmt.Errorf("error creating folder: %w", err)
+		}
+		err = quotaFS.SetMetadata(update.Name, MetadataPool, update.pool)
+		if err != nil {
+			return fmt.Errorf("error charging folder to pool: %w", err)
 		}
 	}
-	err = quotaFS.SetQuota(updateReq.Name, quotaRequested)
+	if !update.expires.IsZero() {
+		err := s.setFolderExpiry(update)
+		if err != nil {
+			return fmt.Errorf("error setting expiry date: %w", err)
+		}
+	}
+	err := quotaFS.SetQuota(update.Name, update.quotaRequested)
 	if err != nil {
-		return http.StatusInternalServerError, fmt.Sprintf(
-			"Failed to create folder: %s", err,
-		)
+		return fmt.Errorf("error setting quota: %w", err)
 	}
-	if currentQuota != 0 {
-		return http.StatusOK, "Your folder's quota has been updated."
+	if update.currentQuota != 0 {
+		return nil
 	}
 	// We need to create the symlink as well.
-	err = s.ProjectFS.CreateLink(
-		updateReq.Name, quotaFS.PathFor(updateReq.Name),
-		submitter.Uid, submitter.Gid,
-	)
+	err = s.ProjectFS.CreateLink(update.Name, quotaFS.PathFor(update.Name), submitter.Uid, submitter.Gid)
 	if err != nil {
-		return http.StatusInternalServerError, fmt.Sprintf(
-			"Failed to create symlink for folder: %s", err,
+		return fmt.Errorf("error creating symlink for folder: %w", err)
+	}
+	return nil
+}
+
+// restoreNote explains until when a folder deleted now can be restored.
+func (s *Server) restoreNote(purgeAt time.Time) string {
+	if s.FreezeTrash {
+		return fmt.Sprintf(
+			"It can be restored until %s and the space it uses stays charged to your allocation until then.",
+			purgeAt.Format(time.DateTime),
 		)
 	}
-	return http.StatusOK, fmt.Sprintf(
-		"Your folder has been created.\n"+
-			"You can access it at %s.\n",
-		s.ProjectFS.PathFor(updateReq.Name),
+	return fmt.Sprintf(
+		"It can be restored until %s and stays charged to your allocation until then.",
+		purgeAt.Format(time.DateTime),
+	)
+}
+
+// notifyUpdate notifies the submitter of an applied update. purgeAt is when a deleted folder will
+// be purged.
+func (s *Server) notifyUpdate(submitter *user.User, update folderUpdate, purgeAt time.Time) {
+	event := Event{
+		User:   submitter.Username,
+		Tier:   update.Tier,
+		Folder: update.Name,
+		Bytes:  update.quotaRequested,
+	}
+	switch {
+	case update.quotaRequested == 0 && update.Permanent:
+		event.Kind = EventFolderDeleted
+		event.Bytes = update.currentQuota
+		event.Message = fmt.Sprintf("Your folder %s in %s is being deleted permanently.", update.Name, update.Tier)
+	case update.quotaRequested == 0:
+		event.Kind = EventFolderDeleted
+		event.Bytes = update.currentQuota
+		event.Message = fmt.Sprintf(
+			"Your folder %s in %s has been moved to the trash. %s",
+			update.Name, update.Tier, s.restoreNote(purgeAt),
+		)
+	case update.currentQuota == update.quotaRequested:
+		// Changing the expiry date is not worth a notification.
+		return
+	case update.currentQuota != 0:
+		event.Kind = EventFolderResized
+		event.Message = fmt.Sprintf(
+			"The quota of your folder %s in %s has been changed from %s to %s.",
+			update.Name, update.Tier, FormatByteSize(update.currentQuota), FormatByteSize(update.quotaRequested),
+		)
+	default:
+		event.Kind = EventFolderCreated
+		event.Message = fmt.Sprintf(
+			"Your folder %s has been created in %s with a quota of %s. You can access it at %s.",
+			update.Name, update.Tier, FormatByteSize(update.quotaRequested), s.ProjectFS.PathFor(update.Name),
+		)
+	}
+	s.notify(event)
+}
+
+// describeChange returns what the update would change about its folder.
+func describeChange(update folderUpdate) string {
+	var desc string
+	switch {
+	case update.quotaRequested == 0 && update.Permanent:
+		desc = fmt.Sprintf("%s in %s would be deleted permanently.", update.Name, update.Tier)
+	case update.quotaRequested == 0:
+		desc = fmt.Sprintf("%s in %s would be moved to the trash.", update.Name, update.Tier)
+	case update.currentQuota == 0:
+		desc = fmt.Sprintf(
+			"%s would be created in %s with a quota of %s.",
+			update.Name, update.Tier, FormatByteSize(update.quotaRequested),
+		)
+	case update.currentQuota == update.quotaRequested:
+		desc = fmt.Sprintf("The quota of %s in %s would stay unchanged.", update.Name, update.Tier)
+	default:
+		desc = fmt.Sprintf(
+			"The quota of %s in %s would change from %s to %s.",
+			update.Name, update.Tier, FormatByteSize(update.currentQuota), FormatByteSize(update.quotaRequested),
+		)
+	}
+	if !update.expires.IsZero() {
+		desc += fmt.Sprintf(" It would be kept until %s.", describeExpiry(update.expires))
+	}
+	return desc
+}
+
+// describeCharge returns how much of the allocation in the tier would be left after an update.
+func describeCharge(tierName, pool string, tierQuota, usedBefore, usedAfter int) string {
+	charged := "Your allocation"
+	if pool != "" {
+		charged = "Pool " + pool
+	}
+	return fmt.Sprintf(
+		"%s in %s would have %s used of %s, leaving %s (currently %s).",
+		charged, tierName, FormatByteSize(usedAfter), FormatByteSize(tierQuota),
+		FormatByteSize(tierQuota-usedAfter), FormatByteSize(tierQuota-usedBefore),
 	)
 }
+
+// describeDryRun returns what a validated update would change without applying it.
+func (s *Server) describeDryRun(update folderUpdate, tierQuota, quotaUsed int, approvers []string) string {
+	var b strings.Builder
+	_, _ = fmt.Fprintln(&b, "This is a dry run, nothing has been changed.")
+	_, _ = fmt.Fprintln(&b, describeChange(update))
+	_, _ = fmt.Fprint(&b, describeCharge(update.Tier, update.pool, tierQuota, quotaUsed, quotaUsed+update.chargeChange()))
+	if update.quotaRequested == 0 && !update.Permanent {
+		_, _ = fmt.Fprint(&b, "\nThe folder stays charged until it is purged from the trash.")
+	}
+	if len(approvers) > 0 {
+		_, _ = fmt.Fprintf(
+			&b, "\nThe update would need to be approved by a member of %s first.", strings.Join(approvers, ", "),
+		)
+	}
+	return b.String()
+}
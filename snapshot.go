@@ -0,0 +1,183 @@
+package storaged
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrSnapshotsUnsupported is returned by a SnapshotFS whose underlying file system cannot take
+// snapshots.
+var ErrSnapshotsUnsupported = errors.New("snapshots are not supported")
+
+// SnapshotFS is implemented by a QuotaFS that can take snapshots of its folders.
+type SnapshotFS interface {
+	// Snapshots returns the snapshots of the project taken by storaged, oldest first.
+	Snapshots(project string) ([]Snapshot, error)
+	// CreateSnapshot takes a snapshot of the project.
+	CreateSnapshot(project string, snapshot Snapshot) error
+	// DeleteSnapshot deletes the named snapshot of the project.
+	DeleteSnapshot(project, name string) error
+	// SnapshotPath returns the absolute path of the read-only contents of the snapshot.
+	SnapshotPath(project, name string) string
+}
+
+// Snapshot is a point-in-time copy of a project folder.
+type Snapshot struct {
+	Name    string
+	Created time.Time
+	// Scheduled is set for snapshots taken by the snapshot schedule rather than by the owner.
+	Scheduled bool
+}
+
+const (
+	snapshotScheduledPrefix = "scheduled-"
+	snapshotManualPrefix    = "manual-"
+	snapshotTimeFormat      = "20060102T150405Z"
+)
+
+// NewSnapshot returns a snapshot taken at the specified time.
+func NewSnapshot(created time.Time, scheduled bool) Snapshot {
+	prefix := snapshotManualPrefix
+	if scheduled {
+		prefix = snapshotScheduledPrefix
+	}
+	created = created.UTC().Truncate(time.Second)
+	return Snapshot{
+		Name:      prefix + created.Format(snapshotTimeFormat),
+		Created:   created,
+		Scheduled: scheduled,
+	}
+}
+
+// ParseSnapshotName returns the snapshot with the specified name, or false if the snapshot was not
+// taken by storaged.
+func ParseSnapshotName(name string) (Snapshot, bool) {
+	scheduled := true
+	timestamp, ok := strings.CutPrefix(name, snapshotScheduledPrefix)
+	if !ok {
+		scheduled = false
+		timestamp, ok = strings.CutPrefix(name, snapshotManualPrefix)
+	}
+	if !ok {
+		return Snapshot{}, false
+	}
+	created, err := time.Parse(snapshotTimeFormat, timestamp)
+	if err != nil {
+		return Snapshot{}, false
+	}
+	return Snapshot{Name: name, Created: created, Scheduled: scheduled}, true
+}
+
+const (
+	// DefaultSnapshotKeep is the number of scheduled snapshots kept per folder by default.
+	DefaultSnapshotKeep = 7
+	// DefaultMaxManualSnapshots is the number of snapshots owners may take per folder by default.
+	DefaultMaxManualSnapshots = 3
+	// snapshotCheckInterval is how often folders are checked for a scheduled snapshot.
+	snapshotCheckInterval = time.Hour
+)
+
+// SnapshotPolicy controls the snapshots of the folders in a tier.
+type SnapshotPolicy struct {
+	// Interval is how often every folder is snapshotted. If zero, only owners take snapshots.
+	Interval time.Duration `toml:"interval"`
+	// Keep is the number of scheduled snapshots kept per folder.
+	Keep int `toml:"keep"`
+	// MaxManual is the number of snapshots owners may take per folder.
+	MaxManual int `toml:"max_manual"`
+}
+
+// Validate returns an error if the policy cannot be applied.
+func (p SnapshotPolicy) Validate() error {
+	switch {
+	case p.Interval < 0:
+		return errors.New("snapshot interval cannot be negative")
+	case p.Interval > 0 && p.Interval < snapshotCheckInterval:
+		return fmt.Errorf("snapshot interval must be at least %s", snapshotCheckInterval)
+	case p.Keep < 0, p.MaxManual < 0:
+		return errors.New("snapshot counts cannot be negative")
+	}
+	return nil
+}
+
+func (p SnapshotPolicy) keep() int {
+	return cmp.Or(p.Keep, DefaultSnapshotKeep)
+}
+
+func (p SnapshotPolicy) maxManual() int {
+	return cmp.Or(p.MaxManual, DefaultMaxManualSnapshots)
+}
+
+// snapshotTier returns the SnapshotFS and policy of the tier, or false if snapshots are not
+// enabled for it.
+func (s *Server) snapshotTier(tierName string) (SnapshotFS, SnapshotPolicy, bool) {
+	policy, ok := s.SnapshotPolicies[tierName]
+	if !ok {
+		return nil, SnapshotPolicy{}, false
+	}
+	snapFS, ok := s.Tiers[tierName].(SnapshotFS)
+	if !ok {
+		return nil, SnapshotPolicy{}, false
+	}
+	return snapFS, policy, true
+}
+
+// takeScheduledSnapshots snapshots every folder whose last scheduled snapshot is older than the
+// interval of its tier and removes scheduled snapshots beyond the number to keep.
+func (s *Server) takeScheduledSnapshots() {
+	now := time.Now()
+	for tierName := range s.Tiers {
+		snapFS, policy, ok := s.snapshotTier(tierName)
+		if !ok || policy.Interval == 0 {
+			continue
+		}
+		entries, _, err := s.folders(context.Background(), tierName, func(_, _ string) bool { return true })
+		if err != nil {
+			log.Printf("failed to list folders to snapshot in %s: %v", tierName, err)
+			continue
+		}
+		for _, entry := range entries {
+			if _, ok := parseTrashPath(entry.Name); ok {
+				continue
+			}
+			err := snapshotFolder(snapFS, entry.Name, policy, now)
+			if err != nil {
+				log.Printf("failed to snapshot %s in %s: %v", entry.Name, tierName, err)
+			}
+		}
+	}
+}
+
+func snapshotFolder(snapFS SnapshotFS, name string, policy SnapshotPolicy, now time.Time) error {
+	snapshots, err := snapFS.Snapshots(name)
+	if err != nil {
+		return err
+	}
+	scheduled := slices.DeleteFunc(snapshots, func(snapshot Snapshot) bool {
+		return !snapshot.Scheduled
+	})
+	// Allow some slack so that a snapshot taken just after a check is not pushed back by a whole
+	// check interval.
+	if len(scheduled) == 0 || now.Sub(scheduled[len(scheduled)-1].Created) >= policy.Interval-time.Minute {
+		snapshot := NewSnapshot(now, true)
+		err := snapFS.CreateSnapshot(name, snapshot)
+		if err != nil {
+			return err
+		}
+		scheduled = append(scheduled, snapshot)
+	}
+	for len(scheduled) > policy.keep() {
+		err := snapFS.DeleteSnapshot(name, scheduled[0].Name)
+		if err != nil {
+			return err
+		}
+		scheduled = scheduled[1:]
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package storaged
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSnapshotFS is an in-memory SnapshotFS. Snapshots only record their names, and SnapshotPath
+// points inside root.
+type fakeSnapshotFS struct {
+	root string
+
+	mutex     sync.Mutex
+	snapshots map[string][]Snapshot
+}
+
+var _ SnapshotFS = (*fakeSnapshotFS)(nil)
+
+func (f *fakeSnapshotFS) Snapshots(project string) ([]Snapshot, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return slices.Clone(f.snapshots[project]), nil
+}
+
+func (f *fakeSnapshotFS) CreateSnapshot(project string, snapshot Snapshot) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.snapshots == nil {
+		f.snapshots = make(map[string][]Snapshot)
+	}
+	for _, existing := range f.snapshots[project] {
+		if existing.Name == snapshot.Name {
+			return fmt.Errorf("snapshot %s of %s: %w", snapshot.Name, project, fs.ErrExist)
+		}
+	}
+	f.snapshots[project] = append(f.snapshots[project], snapshot)
+	return nil
+}
+
+func (f *fakeSnapshotFS) DeleteSnapshot(project, name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	snapshots := f.snapshots[project]
+	i := slices.IndexFunc(snapshots, func(snapshot Snapshot) bool { return snapshot.Name == name })
+	if i < 0 {
+		return fmt.Errorf("snapshot %s of %s: %w", name, project, fs.ErrNotExist)
+	}
+	f.snapshots[project] = slices.Delete(snapshots, i, i+1)
+	return nil
+}
+
+func (f *fakeSnapshotFS) SnapshotPath(project, name string) string {
+	return path.Join(f.root, project, ".snap", name)
+}
+
+// snapshotNames returns the names of the snapshots of the project.
+func snapshotNames(t *testing.T, snapFS SnapshotFS, project string) []string {
+	t.Helper()
+	snapshots, err := snapFS.Snapshots(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(snapshots))
+	for i, snapshot := range snapshots {
+		names[i] = snapshot.Name
+	}
+	return names
+}
+
+func TestSnapshotFolder(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	policy := SnapshotPolicy{Interval: 24 * time.Hour, Keep: 2}
+	manual := NewSnapshot(start.Add(-time.Hour), false)
+
+	tests := []struct {
+		name string
+		// offsets are the times after start at which snapshotFolder is called.
+		offsets []time.Duration
+		want    []Snapshot
+	}{
+		{
+			name:    "first snapshot",
+			offsets: []time.Duration{0},
+			want:    []Snapshot{manual, NewSnapshot(start, true)},
+		},
+		{
+			name:    "within interval",
+			offsets: []time.Duration{0, time.Hour, 23 * time.Hour},
+			want:    []Snapshot{manual, NewSnapshot(start, true)},
+		},
+		{
+			name:    "interval slack",
+			offsets: []time.Duration{0, 24*time.Hour - time.Minute},
+			want:    []Snapshot{manual, NewSnapshot(start, true), NewSnapshot(start.Add(24*time.Hour-time.Minute), true)},
+		},
+		{
+			name:    "keep latest",
+			offsets: []time.Duration{0, 24 * time.Hour, 48 * time.Hour, 72 * time.Hour},
+			want: []Snapshot{
+				manual, NewSnapshot(start.Add(48*time.Hour), true), NewSnapshot(start.Add(72*time.Hour), true),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapFS := &fakeSnapshotFS{}
+			err := snapFS.CreateSnapshot("proj", manual)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, offset := range tt.offsets {
+				err := snapshotFolder(snapFS, "proj", policy, start.Add(offset))
+				if err != nil {
+					t.Fatalf("snapshotFolder at %s: %v", offset, err)
+				}
+			}
+			got, _ := snapFS.Snapshots("proj")
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("snapshots = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// snapshotTier is a tier that supports snapshots.
+type snapshotTier struct {
+	*fakeQuotaFS
+	*fakeSnapshotFS
+}
+
+func TestAttemptSnapshot(t *testing.T) {
+	tier := snapshotTier{newFakeQuotaFS("/hdd"), &fakeSnapshotFS{root: "/hdd"}}
+	s, current := testServer(t, tier, 1<<30, func(cfg *ServerConfig) {
+		cfg.SnapshotPolicies = map[string]SnapshotPolicy{"hdd": {Interval: 24 * time.Hour, MaxManual: 2}}
+	})
+	tier.addFolder("proj", current.Username, 1<<20, 0)
+	scheduled := NewSnapshot(time.Now().Add(-time.Hour), true)
+	older := NewSnapshot(time.Now().Add(-2*time.Hour), false)
+	for _, snapshot := range []Snapshot{scheduled, older} {
+		err := tier.CreateSnapshot("proj", snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	attempt := func(action, snapshot string) (int, string) {
+		return s.attemptSnapshot(current, SnapshotRequest{Action: action, Name: "proj", Tier: "hdd", Snapshot: snapshot})
+	}
+
+	// Take the second manual snapshot a minute ago so that the one taken after deleting the other
+	// does not collide with it.
+	err := tier.CreateSnapshot("proj", NewSnapshot(time.Now().Add(-time.Minute), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, output := attempt("create", "")
+	if status != http.StatusBadRequest || !strings.Contains(output, "You already have 2 snapshots") {
+		t.Errorf("create over limit: %d %s", status, output)
+	}
+	// Scheduled snapshots do not count towards the limit, so they cannot free up room either.
+	status, output = attempt("delete", scheduled.Name)
+	if status != http.StatusBadRequest {
+		t.Errorf("delete scheduled: %d %s", status, output)
+	}
+	if !slices.Contains(snapshotNames(t, tier, "proj"), scheduled.Name) {
+		t.Errorf("scheduled snapshot %s was deleted", scheduled.Name)
+	}
+	status, output = attempt("delete", older.Name)
+	if status != http.StatusOK {
+		t.Fatalf("delete manual: %d %s", status, output)
+	}
+	if slices.Contains(snapshotNames(t, tier, "proj"), older.Name) {
+		t.Errorf("manual snapshot %s was not deleted", older.Name)
+	}
+	status, output = attempt("create", "")
+	if status != http.StatusOK {
+		t.Errorf("create after delete: %d %s", status, output)
+	}
+}
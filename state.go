@@ -0,0 +1,82 @@
+package storaged
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// loadState decodes the JSON state file at statePath into dest. A missing file leaves dest
+// untouched.
+func loadState(statePath string, dest any) error {
+	if statePath == "" {
+		return nil
+	}
+	b, err := os.ReadFile(statePath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("error reading state file: %w", err)
+	}
+	err = json.Unmarshal(b, dest)
+	if err != nil {
+		return fmt.Errorf("error decoding state file %s: %w", statePath, err)
+	}
+	return nil
+}
+
+// saveState atomically replaces the JSON state file at statePath with v. It does nothing if
+// statePath is empty, i.e. if the state should not be persisted.
+func saveState(statePath string, v any) error {
+	if statePath == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return fmt.Errorf("error encoding state: %w", err)
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(statePath), "."+filepath.Base(statePath)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary state file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+	_, err = tmpFile.Write(b)
+	if err != nil {
+		_ = tmpFile.Close()
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	err = os.Rename(tmpFile.Name(), statePath)
+	if err != nil {
+		return fmt.Errorf("error replacing state file: %w", err)
+	}
+	return nil
+}
+
+// randomID returns a short random identifier for stored records.
+func randomID() (string, error) {
+	idBytes := make([]byte, 4)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return "", fmt.Errorf("error generating ID: %w", err)
+	}
+	return hex.EncodeToString(idBytes), nil
+}
+
+// statePath returns the path of the named state file, or an empty string if state should not be
+// persisted.
+func (s *Server) statePath(name string) string {
+	if s.StateDir == "" {
+		return ""
+	}
+	return filepath.Join(s.StateDir, name)
+}
@@ -1,5 +1,7 @@
 package storaged
 
+import "time"
+
 type CheckQuotaRequest struct {
 	User string `json:"user,omitempty"`
 }
@@ -11,4 +13,170 @@ type UpdateRequest struct {
 	Tier string `json:"tier"`
 	// SizeInGB is the quota to assign to the folder.
 	SizeInGB int `json:"size_in_gb"`
+	// Pool is the group whose pooled allocation the folder is charged to. If
+	// empty, the folder is charged to the submitter's own allocation.
+	Pool string `json:"pool,omitempty"`
+	// Permanent skips the trash when deleting the folder. Everything in the folder is removed in
+	// the background and cannot be restored.
+	Permanent bool `json:"permanent,omitempty"`
+	// Confirm must repeat the folder name for permanent deletions.
+	Confirm string `json:"confirm,omitempty"`
+	// ExpiresOn is the last day (YYYY-MM-DD) the folder is kept before being moved to the trash. If
+	// empty, the expiry date of an existing folder is left unchanged.
+	ExpiresOn string `json:"expires_on,omitempty"`
+	// DryRun only validates the update and reports what it would change without applying it.
+	DryRun bool `json:"dry_run,omitempty"`
+	// IdempotencyKey is chosen by the client to make retrying the request safe. Repeating a
+	// request with the same key returns the original response instead of applying it again.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// BatchRequest applies several folder updates together. Either all of them are applied or none.
+type BatchRequest struct {
+	// Operations are the folders to create, resize or delete. Each folder may appear only once,
+	// and deletions cannot be permanent.
+	Operations []UpdateRequest `json:"operations"`
+	// DryRun is as in UpdateRequest.
+	DryRun bool `json:"dry_run,omitempty"`
+	// IdempotencyKey is as in UpdateRequest.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// JobRequest requests the status of background operations.
+type JobRequest struct {
+	// ID is the job to look up. If empty, the submitter's recent jobs are listed.
+	ID string `json:"id,omitempty"`
+}
+
+// GrantRequest is an administrative request to manage per-user grants.
+type GrantRequest struct {
+	// Action is one of "list", "add" or "remove".
+	Action string `json:"action"`
+	// ID is the grant to remove.
+	ID string `json:"id,omitempty"`
+	// User is the user to add the grant for, or to list the grants of.
+	User string `json:"user,omitempty"`
+	// Tier is the storage tier the grant applies to.
+	Tier string `json:"tier,omitempty"`
+	// SizeInGB is the size of the grant.
+	SizeInGB int `json:"size_in_gb,omitempty"`
+	// Override replaces the user's group allocations for the tier instead of adding to them.
+	Override bool `json:"override,omitempty"`
+	// ExpiresOn is the last day (YYYY-MM-DD) the grant applies. If empty, it never expires.
+	ExpiresOn string `json:"expires_on,omitempty"`
+	// Reason is a note describing why the grant was made.
+	Reason string `json:"reason,omitempty"`
+	// IdempotencyKey is as in UpdateRequest.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// OrphanRequest is an administrative request to manage folders whose owner has left.
+type OrphanRequest struct {
+	// Action is one of "list", "reassign", "archive" or "delete".
+	Action string `json:"action"`
+	// Name is the name of the folder to act on.
+	Name string `json:"name,omitempty"`
+	// Tier is the storage tier the folder is in.
+	Tier string `json:"tier,omitempty"`
+	// User is the user to reassign the folder to.
+	User string `json:"user,omitempty"`
+	// Force reassigns the folder even if its quota does not fit in the remaining allocation of
+	// the new owner.
+	Force bool `json:"force,omitempty"`
+	// IdempotencyKey is as in UpdateRequest.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// ApprovalRequest manages folder updates waiting to be approved.
+type ApprovalRequest struct {
+	// Action is one of "list", "approve" or "deny".
+	Action string `json:"action"`
+	// ID is the request to approve or deny.
+	ID string `json:"id,omitempty"`
+	// Reason is a note sent to the requester when denying their request.
+	Reason string `json:"reason,omitempty"`
+	// IdempotencyKey is as in UpdateRequest.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// HistoryRequest requests the usage history of a folder, of all folders of a user or of a whole
+// tier.
+type HistoryRequest struct {
+	// Tier restricts the history to a single tier. It is required if Folder is set.
+	Tier string `json:"tier,omitempty"`
+	// Folder is the folder to retrieve the history of.
+	Folder string `json:"folder,omitempty"`
+	// User is the user to retrieve the history of, summed over all of their folders.
+	User string `json:"user,omitempty"`
+}
+
+// HistoryPoint is the total usage and quota at a point in time.
+type HistoryPoint struct {
+	Time  time.Time `json:"time"`
+	Usage int       `json:"usage"`
+	Quota int       `json:"quota"`
+}
+
+// BreakdownRequest requests the largest subdirectories of a folder.
+type BreakdownRequest struct {
+	// Name is the name of the folder to break down.
+	Name string `json:"name"`
+	// Tier is the storage tier the folder is in.
+	Tier string `json:"tier"`
+	// Depth is how many levels of subdirectories to list. Defaults to 1.
+	Depth int `json:"depth,omitempty"`
+	// Limit is the maximum number of subdirectories to list. Defaults to 20.
+	Limit int `json:"limit,omitempty"`
+}
+
+// RestoreRequest requests a deleted folder to be restored from the trash.
+type RestoreRequest struct {
+	// Name is the name the folder had before it was deleted.
+	Name string `json:"name"`
+	// Tier is the storage tier the folder was deleted from.
+	Tier string `json:"tier"`
+	// ID identifies which deleted folder to restore if the name was deleted more than once.
+	ID string `json:"id,omitempty"`
+	// IdempotencyKey is as in UpdateRequest.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// RenameRequest requests a folder to be renamed.
+type RenameRequest struct {
+	// Name is the current name of the folder.
+	Name string `json:"name"`
+	// Tier is the storage tier the folder is in.
+	Tier string `json:"tier"`
+	// NewName is the name to rename the folder to.
+	NewName string `json:"new_name"`
+	// IdempotencyKey is as in UpdateRequest.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// SnapshotRequest requests an operation on the snapshots of a folder.
+type SnapshotRequest struct {
+	// Action is one of "list", "create", "delete" or "restore".
+	Action string `json:"action"`
+	// Name is the name of the folder.
+	Name string `json:"name"`
+	// Tier is the storage tier the folder is in.
+	Tier string `json:"tier"`
+	// Snapshot is the name of the snapshot to delete or restore from.
+	Snapshot string `json:"snapshot,omitempty"`
+	// Path is the path inside the folder to restore. Defaults to the whole folder.
+	Path string `json:"path,omitempty"`
+	// IdempotencyKey is as in UpdateRequest.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// MigrateRequest requests a folder to be moved to another storage tier.
+type MigrateRequest struct {
+	// Name is the name of the folder to migrate.
+	Name string `json:"name"`
+	// Tier is the storage tier the folder is currently in.
+	Tier string `json:"tier"`
+	// DestinationTier is the storage tier to move the folder to.
+	DestinationTier string `json:"destination_tier"`
+	// IdempotencyKey is as in UpdateRequest.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
@@ -0,0 +1,457 @@
+package storaged
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os/user"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// TrashDir is the directory in each tier that deleted folders are moved to. Project names
+	// cannot contain dots, so it never collides with a project.
+	TrashDir = ".trash"
+	// MetadataDeleted is the metadata key holding when a folder was moved to the trash.
+	MetadataDeleted = "deleted"
+	// MetadataPurging marks a folder in the trash that is being permanently deleted and can no
+	// longer be restored.
+	MetadataPurging = "purging"
+	// MetadataFrozenQuota is the metadata key holding the quota a folder had before it was frozen
+	// in the trash.
+	MetadataFrozenQuota = "frozen_quota"
+	// DefaultTrashRetention is how long deleted folders are kept by default.
+	DefaultTrashRetention = 7 * 24 * time.Hour
+	// purgeProgressInterval is how often the progress of a permanent deletion is updated.
+	purgeProgressInterval = 5 * time.Second
+)
+
+// trashedFolder is a folder in the trash of a tier.
+type trashedFolder struct {
+	// Path is the path of the folder relative to the tier.
+	Path string
+	// Name is the name the folder had before it was deleted.
+	Name string
+	// ID distinguishes folders deleted under the same name.
+	ID string
+}
+
+// parseTrashPath returns the trashed folder at the path relative to the tier, or false if the
+// path is not in the trash.
+func parseTrashPath(folderPath string) (trashedFolder, bool) {
+	entry, ok := strings.CutPrefix(folderPath, TrashDir+"/")
+	if !ok {
+		return trashedFolder{}, false
+	}
+	name, id, ok := strings.Cut(entry, ".")
+	if !ok {
+		return trashedFolder{}, false
+	}
+	return trashedFolder{Path: folderPath, Name: name, ID: id}, true
+}
+
+func (s *Server) trashRetention() time.Duration {
+	return cmp.Or(s.TrashRetention, DefaultTrashRetention)
+}
+
+// moveToTrash moves the folder to the trash of the tier and returns the trashed folder and when
+// it will be purged. The caller must hold updateMutex.
+func (s *Server) moveToTrash(tierName, name string) (trashedFolder, time.Time, error) {
+	quotaFS := s.Tiers[tierName]
+	_, err := fs.Stat(quotaFS, TrashDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Only root should be able to look inside the trash.
+		err = quotaFS.CreateFolder(TrashDir, "0", "0")
+	}
+	if err != nil {
+		return trashedFolder{}, time.Time{}, fmt.Errorf("error preparing trash: %w", err)
+	}
+	id, err := randomID()
+	if err != nil {
+		return trashedFolder{}, time.Time{}, err
+	}
+	trashPath := path.Join(TrashDir, name+"."+id)
+	now := time.Now()
+	err = quotaFS.SetMetadata(name, MetadataDeleted, now.Format(time.RFC3339))
+	if err != nil {
+		return trashedFolder{}, time.Time{}, fmt.Errorf("error marking folder as deleted: %w", err)
+	}
+	err = quotaFS.RenameFolder(name, trashPath)
+	if err != nil {
+		_ = quotaFS.SetMetadata(name, MetadataDeleted, "")
+		return trashedFolder{}, time.Time{}, fmt.Errorf("error moving folder to trash: %w", err)
+	}
+	s.refreshFolder(tierName, trashPath)
+	if s.FreezeTrash {
+		usage, err := quotaFS.Usage(trashPath)
+		var quota int
+		if err == nil {
+			quota, err = quotaFS.Quota(trashPath)
+		}
+		if err == nil {
+			// The folder gets its quota back if it is restored.
+			err = quotaFS.SetMetadata(trashPath, MetadataFrozenQuota, strconv.Itoa(quota))
+		}
+		if err == nil {
+			// A quota of zero means unbounded, so never go below a single byte.
+			err = quotaFS.SetQuota(trashPath, max(usage, 1))
+		}
+		if err != nil {
+			// The folder is already in the trash, so only its charge is wrong.
+			log.Printf("failed to freeze %s in %s: %v", trashPath, tierName, err)
+		}
+	}
+	return trashedFolder{Path: trashPath, Name: name, ID: id}, now.Add(s.trashRetention()), nil
+}
+
+// startPurge marks the trashed folder as being purged and queues its removal. The caller must
+// hold updateMutex.
+func (s *Server) startPurge(owner *user.User, tierName string, folder trashedFolder) (Job, error) {
+	quotaFS := s.Tiers[tierName]
+	err := quotaFS.SetMetadata(folder.Path, MetadataPurging, "true")
+	if err != nil {
+		return Job{}, fmt.Errorf("error marking folder for deletion: %w", err)
+	}
+	return s.jobs.Submit(Job{
+		Kind:        JobKindDelete,
+		Owner:       owner.Username,
+		Tier:        tierName,
+		Folder:      folder.Path,
+		Description: fmt.Sprintf("Permanently deleting %s from %s", folder.Name, tierName),
+	})
+}
+
+// runPurge removes a folder in the trash. Once the removal has started, it can no longer be
+// cancelled.
+func (s *Server) runPurge(ctx context.Context, job Job, progress func(done, total int)) error {
+	quotaFS := s.Tiers[job.Tier]
+	defer s.refreshFolder(job.Tier, job.Folder)
+	if ctx.Err() != nil {
+		// Cancelled before it started, so the folder goes back to being restorable.
+		s.updateMutex.Lock()
+		defer s.updateMutex.Unlock()
+		return errors.Join(ctx.Err(), quotaFS.SetMetadata(job.Folder, MetadataPurging, ""))
+	}
+	total, err := quotaFS.Usage(job.Folder)
+	if err != nil {
+		total = 0
+	}
+	progress(0, total)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		// Recursive statistics shrink as files are removed, so they double as progress.
+		ticker := time.NewTicker(purgeProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				remaining, err := quotaFS.Usage(job.Folder)
+				if err == nil {
+					progress(max(total-remaining, 0), total)
+				}
+			}
+		}
+	}()
+	err = quotaFS.RemoveAll(job.Folder)
+	if err != nil {
+		return err
+	}
+	progress(total, total)
+	return nil
+}
+
+// trashedFolders returns the folders in the trash of the tier that can still be restored.
+func (s *Server) trashedFolders(tierName string) ([]trashedFolder, error) {
+	quotaFS := s.Tiers[tierName]
+	entries, err := fs.ReadDir(quotaFS, TrashDir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("error reading trash: %w", err)
+	}
+	var result []trashedFolder
+	for _, entry := range entries {
+		folder, ok := parseTrashPath(path.Join(TrashDir, entry.Name()))
+		if !ok {
+			continue
+		}
+		result = append(result, folder)
+	}
+	return result, nil
+}
+
+// deletedAt returns when the trashed folder was deleted.
+func deletedAt(quotaFS QuotaFS, folderPath string) (time.Time, error) {
+	deleted, err := quotaFS.Metadata(folderPath, MetadataDeleted)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, deleted)
+}
+
+// trashExpired reports whether the trashed folder is past the retention period or being deleted
+// permanently, in which case it can no longer be restored.
+func (s *Server) trashExpired(quotaFS QuotaFS, folderPath string, now time.Time) (bool, error) {
+	purging, err := quotaFS.Metadata(folderPath, MetadataPurging)
+	if err != nil {
+		return false, err
+	}
+	if purging != "" {
+		return true, nil
+	}
+	deleted, err := deletedAt(quotaFS, folderPath)
+	if err != nil {
+		return false, err
+	}
+	return now.Sub(deleted) > s.trashRetention(), nil
+}
+
+// describePurge returns when the trashed folder will be purged for the quota report.
+func (s *Server) describePurge(tierName string, folder trashedFolder) string {
+	quotaFS := s.Tiers[tierName]
+	purging, err := quotaFS.Metadata(folder.Path, MetadataPurging)
+	if err != nil {
+		return ""
+	}
+	if purging != "" {
+		return ", being deleted permanently"
+	}
+	deleted, err := deletedAt(quotaFS, folder.Path)
+	if err != nil {
+		return ""
+	}
+	return ", restorable until " + deleted.Add(s.trashRetention()).Format(time.DateTime)
+}
+
+// purgeTrash permanently removes folders that have been in the trash for longer than the
+// retention period.
+func (s *Server) purgeTrash() {
+	now := time.Now()
+	for tierName, quotaFS := range s.Tiers {
+		folders, err := s.trashedFolders(tierName)
+		if err != nil {
+			log.Printf("failed to purge trash of %s: %v", tierName, err)
+			continue
+		}
+		for _, folder := range folders {
+			if s.jobs.Active(tierName, folder.Path) {
+				continue
+			}
+			expired, err := s.trashExpired(quotaFS, folder.Path, now)
+			if err != nil {
+				log.Printf("failed to read deletion time of %s in %s: %v", folder.Path, tierName, err)
+				continue
+			}
+			if !expired {
+				continue
+			}
+			// Restores refuse expired folders, so this does not need to hold updateMutex while
+			// removing what could be millions of files.
+			err = quotaFS.RemoveAll(folder.Path)
+			s.refreshFolder(tierName, folder.Path)
+			if err != nil {
+				log.Printf("failed to purge %s in %s: %v", folder.Path, tierName, err)
+				continue
+			}
+			log.Printf("purged %s in %s", folder.Path, tierName)
+		}
+	}
+}
+
+func (s *Server) handleRestoreFolder(writer http.ResponseWriter, req *http.Request) {
+	var restoreReq RestoreRequest
+	submitter, ok := s.readRequest(writer, req, &restoreReq)
+	if !ok {
+		return
+	}
+	if _, ok := s.Tiers[restoreReq.Tier]; !ok {
+		http.Error(writer, fmt.Sprintf("Invalid tier requested: %q does not exist!", restoreReq.Tier), http.StatusBadRequest)
+		return
+	}
+	err := ValidateProjectName(restoreReq.Name)
+	if err != nil {
+		http.Error(writer, "Invalid name requested: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, output := s.attemptRestore(req.Context(), submitter, restoreReq)
+	if status == http.StatusInternalServerError {
+		output += "\n\nTry again later and contact administrators if the folder is in an unexpected state."
+	}
+	writer.WriteHeader(status)
+	_, _ = fmt.Fprintln(writer, output)
+}
+
+func (s *Server) attemptRestore(
+	ctx context.Context, submitter *user.User, restoreReq RestoreRequest,
+) (statusCode int, output string) {
+	quotaFS := s.Tiers[restoreReq.Tier]
+	name := restoreReq.Name
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+	folders, err := s.trashedFolders(restoreReq.Tier)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to list deleted folders: " + err.Error()
+	}
+	now := time.Now()
+	var candidates []trashedFolder
+	for _, folder := range folders {
+		if folder.Name != name || (restoreReq.ID != "" && folder.ID != restoreReq.ID) {
+			continue
+		}
+		owner, err := quotaFS.FileOwner(folder.Path)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to fetch owner for deleted folder: " + err.Error()
+		}
+		if owner != submitter.Username {
+			continue
+		}
+		expired, err := s.trashExpired(quotaFS, folder.Path, now)
+		if err != nil || expired {
+			// The folder is about to be purged.
+			continue
+		}
+		candidates = append(candidates, folder)
+	}
+	switch len(candidates) {
+	case 0:
+		return http.StatusBadRequest, "You have no deleted folder named " + name + " in " + restoreReq.Tier + "."
+	case 1:
+	default:
+		ids := make([]string, 0, len(candidates))
+		for _, folder := range candidates {
+			ids = append(ids, folder.ID)
+		}
+		slices.Sort(ids)
+		return http.StatusBadRequest, fmt.Sprintf(
+			"You deleted %s more than once. Specify which one to restore: %s",
+			name, strings.Join(ids, ", "),
+		)
+	}
+	folder := candidates[0]
+	defer s.refreshFolder(restoreReq.Tier, folder.Path)
+	defer s.refreshFolder(restoreReq.Tier, name)
+	taken, err := s.nameTaken(name)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check folder existence: " + err.Error()
+	}
+	if taken {
+		return http.StatusBadRequest, "Folder " + name + " already exists. Rename or delete it first."
+	}
+
+	deleted, err := quotaFS.Metadata(folder.Path, MetadataDeleted)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to read deleted folder: " + err.Error()
+	}
+	frozenQuota, err := quotaFS.Metadata(folder.Path, MetadataFrozenQuota)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to read deleted folder: " + err.Error()
+	}
+	currentQuota, err := quotaFS.Quota(folder.Path)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to fetch quota of deleted folder: " + err.Error()
+	}
+	restoredQuota, quotaNote, err := s.thawedQuota(
+		ctx, submitter, restoreReq.Tier, folder.Path, currentQuota, frozenQuota,
+	)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to calculate quota: " + err.Error()
+	}
+	previousExpires, err := quotaFS.Metadata(folder.Path, MetadataExpires)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to read deleted folder: " + err.Error()
+	}
+	expires, err := folderExpiry(quotaFS, folder.Path)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check expiry date of deleted folder: " + err.Error()
+	}
+	expired := !expires.IsZero() && !expires.After(now)
+	if expired {
+		// Give the owner time to extend the folder before it expires again.
+		expires = now.Add(s.ExpiryPolicies[restoreReq.Tier].warning())
+	}
+
+	err = quotaFS.RenameFolder(folder.Path, name)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to restore folder: " + err.Error()
+	}
+	// undo puts the folder back into the trash as it was if restoring it fails halfway.
+	undo := func() {
+		_ = quotaFS.SetQuota(name, currentQuota)
+		_ = quotaFS.RenameFolder(name, folder.Path)
+		_ = quotaFS.SetMetadata(folder.Path, MetadataDeleted, deleted)
+		_ = quotaFS.SetMetadata(folder.Path, MetadataFrozenQuota, frozenQuota)
+		_ = quotaFS.SetMetadata(folder.Path, MetadataExpires, previousExpires)
+	}
+	err = errors.Join(
+		quotaFS.SetMetadata(name, MetadataDeleted, ""),
+		quotaFS.SetMetadata(name, MetadataFrozenQuota, ""),
+	)
+	if err == nil && expired {
+		err = quotaFS.SetMetadata(name, MetadataExpires, formatExpiry(expires))
+	}
+	if err == nil && restoredQuota != currentQuota {
+		err = quotaFS.SetQuota(name, restoredQuota)
+	}
+	if err != nil {
+		undo()
+		return http.StatusInternalServerError, "Failed to restore folder: " + err.Error()
+	}
+	err = s.ProjectFS.CreateLink(name, quotaFS.PathFor(name), submitter.Uid, submitter.Gid)
+	if err != nil {
+		undo()
+		return http.StatusInternalServerError, "Failed to create symlink for folder: " + err.Error()
+	}
+	if expired {
+		return http.StatusOK, fmt.Sprintf(
+			"Your folder %s has been restored.%s\n"+
+				"It had expired and is now kept until %s. Extend its expiry date to keep it longer.",
+			name, quotaNote, describeExpiry(expires),
+		)
+	}
+	return http.StatusOK, "Your folder " + name + " has been restored." + quotaNote
+}
+
+// thawedQuota returns the quota a folder in the trash should be restored with, along with a note
+// for the owner if it differs from the quota it had before it was deleted. frozenQuota is the
+// MetadataFrozenQuota of the folder. The caller must hold updateMutex.
+func (s *Server) thawedQuota(
+	ctx context.Context, owner *user.User, tierName, folderPath string, currentQuota int, frozenQuota string,
+) (quota int, note string, err error) {
+	quotaFS := s.Tiers[tierName]
+	if frozenQuota == "" {
+		return currentQuota, "", nil
+	}
+	originalQuota, err := strconv.Atoi(frozenQuota)
+	if err != nil {
+		return 0, "", fmt.Errorf("error parsing quota of folder before it was frozen: %w", err)
+	}
+	pool, err := quotaFS.Metadata(folderPath, MetadataPool)
+	if err != nil {
+		return 0, "", fmt.Errorf("error reading pool of folder: %w", err)
+	}
+	tierQuota, quotaUsed, err := s.chargedQuota(ctx, owner, tierName, pool)
+	if err != nil {
+		return 0, "", err
+	}
+	// The frozen quota is already charged while the folder is in the trash.
+	if originalQuota-currentQuota > tierQuota-quotaUsed {
+		return currentQuota, fmt.Sprintf(
+			"\nIts quota stays at %s instead of the %s it had before, as there is not enough allocation left. "+
+				"Raise it once there is.",
+			FormatByteSize(currentQuota), FormatByteSize(originalQuota),
+		), nil
+	}
+	return originalQuota, "", nil
+}
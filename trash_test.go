@@ -0,0 +1,57 @@
+package storaged
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreExpiredFolder(t *testing.T) {
+	tier := newFakeQuotaFS("/hdd")
+	s, current := testServer(t, tier, 1<<40, nil)
+	projectFS := s.ProjectFS.(*fakeQuotaFS)
+	tier.addFolder("proj", current.Username, 1<<30, 0)
+	expired := formatExpiry(time.Now().Add(-time.Hour).Truncate(time.Second))
+	err := tier.SetMetadata("proj", MetadataExpires, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trashed, _, err := s.moveToTrash("hdd", "proj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoreReq := RestoreRequest{Name: "proj", Tier: "hdd"}
+
+	// The folder goes back into the trash as it was if it cannot be linked.
+	projectFS.fail("CreateLink", "proj", errors.New("link failed"))
+	status, output := s.attemptRestore(context.Background(), current, restoreReq)
+	if status != http.StatusInternalServerError {
+		t.Fatalf("restore without link: %d %s", status, output)
+	}
+	folder := tier.folder(trashed.Path)
+	if folder == nil {
+		t.Fatalf("%s is no longer in the trash", trashed.Path)
+	}
+	if folder.metadata[MetadataExpires] != expired || folder.metadata[MetadataDeleted] == "" {
+		t.Errorf("trashed folder has metadata %v after failed restore", folder.metadata)
+	}
+
+	projectFS.fail("CreateLink", "proj", nil)
+	status, output = s.attemptRestore(context.Background(), current, restoreReq)
+	if status != http.StatusOK || !strings.Contains(output, "It had expired") {
+		t.Fatalf("restore: %d %s", status, output)
+	}
+	expires, err := folderExpiry(tier, "proj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expires.After(time.Now()) {
+		t.Errorf("restored folder still expires at %s", expires)
+	}
+	if projectFS.link("proj") != tier.PathFor("proj") {
+		t.Errorf("link of restored folder points to %q", projectFS.link("proj"))
+	}
+}